@@ -0,0 +1,11 @@
+// Package api contains the documents of the k8sbox REST API
+package api
+
+import (
+	_ "embed"
+)
+
+// OpenAPI is the OpenAPI document of the REST API k8sbox serve exposes
+//
+//go:embed openapi.yaml
+var OpenAPI []byte
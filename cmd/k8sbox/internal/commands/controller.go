@@ -0,0 +1,40 @@
+// Package commands is an entry point for every single cobra command available
+package commands
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/twelvee/k8sbox/internal/k8sbox/handlers"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+)
+
+// NewControllerCommand is controller command entry point
+func NewControllerCommand() *cobra.Command {
+	var (
+		command *cobra.Command
+		options structs.ControllerOptions
+
+		getExample = `
+		k8sbox controller // reconcile every saved environment of the cluster once a minute
+
+		k8sbox controller --interval=5m --lease-namespace=k8sbox // reconcile every 5 minutes, replicas compete for a lease in the k8sbox namespace
+		`
+	)
+	command = &cobra.Command{
+		Use:   "controller",
+		Short: "Reconcile environments in the cluster",
+		Long: "Run k8sbox as a long-running controller that walks every saved environment of the cluster. " +
+			"It deletes expired environments, creates deleted objects again, reports drifted ones and records the environment statuses. " +
+			"Replicas elect a leader through a lease, so only one of them reconciles at a time.",
+		Example: getExample,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			handlers.HandleControllerCommand(command.Context(), options)
+			return nil
+		},
+	}
+	command.Flags().DurationVar(&options.Interval, "interval", structs.DEFAULT_RECONCILE_INTERVAL, "The time between two reconciles.")
+	command.Flags().StringVar(&options.LeaseName, "lease-name", structs.DEFAULT_LEASE_NAME, "The name of the lease the replicas compete for.")
+	command.Flags().StringVar(&options.LeaseNamespace, "lease-namespace", "", "The namespace of the lease. Defaults to the POD_NAMESPACE variable or the default namespace.")
+	command.Flags().StringVar(&options.Identity, "id", "", "The identity of the replica. Defaults to the host name.")
+	return command
+}
@@ -11,11 +11,14 @@ func NewDeleteCommand() *cobra.Command {
 	var (
 		command   *cobra.Command
 		namespace string
+		withCRDs  bool
 
 		getExample = `
 		k8sbox delete environment {EnvironmentID} -n test // will delete the environment by reference to its ID
 
 		k8sbox delete env {EnvironmentID} --namespace=default // will delete the environment by reference to its ID
+
+		k8sbox delete env {EnvironmentID} -n test --with-crds // will delete the environment together with the CRDs its charts installed
 		`
 	)
 	command = &cobra.Command{
@@ -25,10 +28,11 @@ func NewDeleteCommand() *cobra.Command {
 		Example: getExample,
 		Args:    cobra.MinimumNArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			handlers.HandleDeleteCommand(command.Context(), args[0], args[1], namespace)
+			handlers.HandleDeleteCommand(command.Context(), args[0], args[1], namespace, withCRDs)
 			return nil
 		},
 	}
 	command.Flags().StringVarP(&namespace, "namespace", "n", "default", "The ID of the resource to be deleted.")
+	command.Flags().BoolVar(&withCRDs, "with-crds", false, "Also delete the CRDs installed from the charts crds/ directories, unless other environments still use them.")
 	return command
 }
@@ -0,0 +1,34 @@
+// Package commands is an entry point for every single cobra command available
+package commands
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/twelvee/k8sbox/internal/k8sbox/handlers"
+)
+
+// NewDiffCommand is diff command entry point
+func NewDiffCommand() *cobra.Command {
+	var (
+		command  *cobra.Command
+		tomlFile string
+
+		getExample = `
+		k8sbox diff -f /examples/environments/example_environment.toml // Shows what running the environment would change in the cluster
+
+		k8sbox diff --file /examples/environments/example_environment.toml || echo "changes found" // Exits with 1 if anything would change
+		`
+	)
+	command = &cobra.Command{
+		Use:     "diff",
+		Short:   "Diff the environment against the cluster",
+		Long:    "Compare the environment with the toml specification against the live objects and the saved environment state. Exits with 0 when there are no differences, 1 when there are and 2 when something went wrong.",
+		Example: getExample,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			handlers.HandleDiffCommand(command.Context(), tomlFile)
+			return nil
+		},
+	}
+	command.Flags().StringVarP(&tomlFile, "file", "f", "", "Path toml file specifying the environment to be compared.")
+	command.MarkFlagRequired("file")
+	return command
+}
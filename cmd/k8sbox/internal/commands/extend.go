@@ -0,0 +1,34 @@
+// Package commands is an entry point for every single cobra command available
+package commands
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/twelvee/k8sbox/internal/k8sbox/handlers"
+)
+
+// NewExtendCommand is extend command entry point
+func NewExtendCommand() *cobra.Command {
+	var (
+		command   *cobra.Command
+		namespace string
+
+		getExample = `
+		k8sbox extend environment {EnvironmentID} 24h -n test // push the expiry of the environment back by a day
+
+		k8sbox extend env {EnvironmentID} 30m --namespace=default // push the expiry of the environment back by half an hour
+		`
+	)
+	command = &cobra.Command{
+		Use:     "extend",
+		Short:   "Extend the life of a resource",
+		Long:    "Push the expiry of the resource back, so the gc command leaves it alone for longer. An expired resource is extended from now on. Use requires specifying the type of the resource as the first argument and the duration as the last one.",
+		Example: getExample,
+		Args:    cobra.MinimumNArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			handlers.HandleExtendCommand(command.Context(), args[0], args[1], args[2], namespace)
+			return nil
+		},
+	}
+	command.Flags().StringVarP(&namespace, "namespace", "n", "default", "The namespace of the environment.")
+	return command
+}
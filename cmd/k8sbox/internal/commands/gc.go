@@ -0,0 +1,36 @@
+// Package commands is an entry point for every single cobra command available
+package commands
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/twelvee/k8sbox/internal/k8sbox/handlers"
+)
+
+// NewGCCommand is gc command entry point
+func NewGCCommand() *cobra.Command {
+	var (
+		command   *cobra.Command
+		namespace string
+		dryRun    bool
+
+		getExample = `
+		k8sbox gc // delete every environment whose TTL has passed
+
+		k8sbox gc -n test --dry-run // list the expired environments of the test namespace without deleting them
+		`
+	)
+	command = &cobra.Command{
+		Use:     "gc",
+		Short:   "Delete expired environments",
+		Long:    "Delete every environment that has outlived the TTL set by its ttl field. Environments of all namespaces are collected unless the namespace is specified.",
+		Example: getExample,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			handlers.HandleGCCommand(command.Context(), namespace, dryRun)
+			return nil
+		},
+	}
+	command.Flags().StringVarP(&namespace, "namespace", "n", "", "Collect only the environments of the namespace.")
+	command.Flags().BoolVar(&dryRun, "dry-run", false, "Only list the environments that would be deleted.")
+	return command
+}
@@ -0,0 +1,34 @@
+// Package commands is an entry point for every single cobra command available
+package commands
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/twelvee/k8sbox/internal/k8sbox/handlers"
+)
+
+// NewHistoryCommand is history command entry point
+func NewHistoryCommand() *cobra.Command {
+	var (
+		command   *cobra.Command
+		namespace string
+
+		getExample = `
+		k8sbox history environment {EnvironmentID} -n test // get a list of the latest revisions of the environment
+
+		k8sbox history env {EnvironmentID} --namespace=default // get a list of the latest revisions of the environment
+		`
+	)
+	command = &cobra.Command{
+		Use:     "history",
+		Short:   "Get the revisions of a resource",
+		Long:    "Get a list of the latest deploys of the resource with their outcome. Use requires specifying the type of the resource as the first argument.",
+		Example: getExample,
+		Args:    cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			handlers.HandleHistoryCommand(command.Context(), args[0], args[1], namespace)
+			return nil
+		},
+	}
+	command.Flags().StringVarP(&namespace, "namespace", "n", "default", "The namespace of the environment.")
+	return command
+}
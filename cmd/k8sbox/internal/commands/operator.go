@@ -0,0 +1,43 @@
+// Package commands is an entry point for every single cobra command available
+package commands
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/twelvee/k8sbox/internal/k8sbox/handlers"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+)
+
+// NewOperatorCommand is operator command entry point
+func NewOperatorCommand() *cobra.Command {
+	var (
+		command *cobra.Command
+		options structs.ControllerOptions
+
+		getExample = `
+		k8sbox operator // deploy the Environment custom resources of the cluster
+
+		k8sbox operator --interval=30s --lease-namespace=k8sbox // look for changed resources every 30 seconds
+
+		k8sbox operator --allow-url-sources // let the resources point to the charts and the manifests by URL
+		`
+	)
+	command = &cobra.Command{
+		Use:   "operator",
+		Short: "Deploy Environment custom resources",
+		Long: "Run k8sbox as an operator of the Environment custom resources (build/kubernetes/environment-crd.yaml). " +
+			"An environment is deployed every time its spec changes, its status reports the Rendering, Deploying, Ready and Failed conditions and the state of every box. " +
+			"Deleting the resource deletes the environment, which always lives in the namespace of the resource. Replicas elect a leader through a lease, so only one of them deploys at a time.",
+		Example: getExample,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			handlers.HandleOperatorCommand(command.Context(), options)
+			return nil
+		},
+	}
+	command.Flags().DurationVar(&options.Interval, "interval", structs.DEFAULT_OPERATOR_INTERVAL, "The time between two looks for changed resources.")
+	command.Flags().StringVar(&options.LeaseName, "lease-name", structs.DEFAULT_OPERATOR_LEASE_NAME, "The name of the lease the replicas compete for.")
+	command.Flags().StringVar(&options.LeaseNamespace, "lease-namespace", "", "The namespace of the lease. Defaults to the POD_NAMESPACE variable or the default namespace.")
+	command.Flags().StringVar(&options.Identity, "id", "", "The identity of the replica. Defaults to the host name.")
+	command.Flags().BoolVar(&options.AllowURLSources, "allow-url-sources", false, "Download the charts and the manifests of the resources from their URLs. The downloads come from inside the cluster.")
+	return command
+}
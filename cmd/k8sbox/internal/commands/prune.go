@@ -0,0 +1,36 @@
+// Package commands is an entry point for every single cobra command available
+package commands
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/twelvee/k8sbox/internal/k8sbox/handlers"
+)
+
+// NewPruneCommand is prune command entry point
+func NewPruneCommand() *cobra.Command {
+	var (
+		command   *cobra.Command
+		namespace string
+		dryRun    bool
+
+		getExample = `
+		k8sbox prune environment {EnvironmentID} --dry-run // list the leftover objects of the environment without deleting them
+
+		k8sbox prune env {EnvironmentID} -n test // delete the labelled objects that are not a part of the environment render anymore
+		`
+	)
+	command = &cobra.Command{
+		Use:     "prune",
+		Short:   "Delete the leftover objects of a resource",
+		Long:    "Find every object that carries the k8sbox labels of the resource across all namespaced API resources and delete the ones that are not in its saved render. If the resource is not saved anymore, all of its objects are deleted. Use requires specifying the type of the resource as the first argument.",
+		Example: getExample,
+		Args:    cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			handlers.HandlePruneCommand(command.Context(), args[0], args[1], namespace, dryRun)
+			return nil
+		},
+	}
+	command.Flags().StringVarP(&namespace, "namespace", "n", "default", "The namespace of the environment.")
+	command.Flags().BoolVar(&dryRun, "dry-run", false, "Only list the objects that would be deleted.")
+	return command
+}
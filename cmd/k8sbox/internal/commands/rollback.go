@@ -0,0 +1,44 @@
+// Package commands is an entry point for every single cobra command available
+package commands
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/twelvee/k8sbox/internal/k8sbox/handlers"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+)
+
+// NewRollbackCommand is rollback command entry point
+func NewRollbackCommand() *cobra.Command {
+	var (
+		command   *cobra.Command
+		namespace string
+		revision  int
+		options   structs.DeployOptions
+
+		getExample = `
+		k8sbox rollback environment {EnvironmentID} -n test // will roll the environment back to its last succeeded revision
+
+		k8sbox rollback env {EnvironmentID} -n test --revision 3 // will roll the environment back to the revision 3
+
+		k8sbox rollback env {EnvironmentID} -n test --revision 3 --atomic --wait // will restore the current revision if the rollback fails
+		`
+	)
+	command = &cobra.Command{
+		Use:     "rollback",
+		Short:   "Roll back a resource",
+		Long:    "Apply the render of an earlier revision of the resource. The rollback is saved as a new revision. Use requires specifying the type of the resource as the first argument.",
+		Example: getExample,
+		Args:    cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			handlers.HandleRollbackCommand(command.Context(), args[0], args[1], namespace, revision, options)
+			return nil
+		},
+	}
+	command.Flags().StringVarP(&namespace, "namespace", "n", "default", "The namespace of the environment.")
+	command.Flags().IntVar(&revision, "revision", 0, "The revision to roll back to. The last succeeded revision before the current one is used by default.")
+	command.Flags().IntVar(&options.Concurrency, "concurrency", structs.DEFAULT_CONCURRENCY, "The number of independent boxes applied at once.")
+	command.Flags().BoolVar(&options.Wait, "wait", false, "Wait until every box of the environment is ready.")
+	command.Flags().DurationVar(&options.Timeout, "timeout", structs.DEFAULT_WAIT_TIMEOUT, "The time to wait for the environment to become ready when --wait is set.")
+	command.Flags().BoolVar(&options.Atomic, "atomic", false, "Restore the current revision if the rollback fails.")
+	return command
+}
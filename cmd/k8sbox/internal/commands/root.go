@@ -21,6 +21,17 @@ func NewRootCommand() *cobra.Command {
 	root.AddCommand(NewGetCommand())
 	root.AddCommand(NewDeleteCommand())
 	root.AddCommand(NewDescribeCommand())
+	root.AddCommand(NewTemplateCommand())
+	root.AddCommand(NewDiffCommand())
+	root.AddCommand(NewTestCommand())
+	root.AddCommand(NewHistoryCommand())
+	root.AddCommand(NewRollbackCommand())
+	root.AddCommand(NewPruneCommand())
+	root.AddCommand(NewGCCommand())
+	root.AddCommand(NewExtendCommand())
+	root.AddCommand(NewControllerCommand())
+	root.AddCommand(NewOperatorCommand())
+	root.AddCommand(NewServeCommand())
 
 	return root
 }
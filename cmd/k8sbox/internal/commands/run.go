@@ -4,6 +4,7 @@ package commands
 import (
 	"github.com/spf13/cobra"
 	"github.com/twelvee/k8sbox/internal/k8sbox/handlers"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
 )
 
 // NewRunCommand is run command entry point
@@ -11,11 +12,20 @@ func NewRunCommand() *cobra.Command {
 	var (
 		command  *cobra.Command
 		tomlFile string
+		options  structs.DeployOptions
 
 		getExample = `
 		k8sbox run --file /examples/environments/example_environment.toml // Rolls out the environment based on the toml specification
 
 		k8sbox run -f /examples/environments/example_environment.toml // Rolls out the environment based on the toml specification
+
+		k8sbox run -f /examples/environments/example_environment.toml --apply // Updates the already rolled out environment in place
+
+		k8sbox run -f /examples/environments/example_environment.toml --wait --timeout 10m // Waits up to 10 minutes for every box to become ready
+
+		k8sbox run -f /examples/environments/example_environment.toml --atomic --wait // Rolls back every change if the environment fails to become ready
+
+		k8sbox run -f /examples/environments/example_environment.toml --dry-run=server // Validates every object against the cluster without persisting anything
 		`
 	)
 	command = &cobra.Command{
@@ -24,11 +34,17 @@ func NewRunCommand() *cobra.Command {
 		Long:    "Run the environment with the toml specification.",
 		Example: getExample,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			handlers.HandleRunCommand(command.Context(), tomlFile)
+			handlers.HandleRunCommand(command.Context(), tomlFile, options)
 			return nil
 		},
 	}
 	command.Flags().StringVarP(&tomlFile, "file", "f", "", "Path toml file specifying the environment to be created.")
 	command.MarkFlagRequired("file")
+	command.Flags().StringVar((*string)(&options.DryRun), "dry-run", "", "Preview the deployment without persisting anything. Must be \"client\" or \"server\".")
+	command.Flags().IntVar(&options.Concurrency, "concurrency", structs.DEFAULT_CONCURRENCY, "The number of independent boxes installed at once.")
+	command.Flags().BoolVar(&options.Apply, "apply", false, "Update the already rolled out environment with server-side apply instead of deleting and recreating it. Objects removed from the specification are pruned.")
+	command.Flags().BoolVar(&options.Wait, "wait", false, "Wait until every deployment, stateful set, daemon set, job, PVC, service and ingress is ready. Boxes start only when the boxes they depend on are ready, with or without --wait.")
+	command.Flags().DurationVar(&options.Timeout, "timeout", structs.DEFAULT_WAIT_TIMEOUT, "The time to wait for the environment to become ready when --wait is set, and for the boxes other boxes depend on. Hooks have to complete within it as well.")
+	command.Flags().BoolVar(&options.Atomic, "atomic", false, "Roll back every change if the deploy fails and restore the previous environment if it was deployed. The environment is saved only when the deploy succeeds.")
 	return command
 }
@@ -0,0 +1,43 @@
+// Package commands is an entry point for every single cobra command available
+package commands
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/twelvee/k8sbox/internal/k8sbox/handlers"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+)
+
+// NewServeCommand is serve command entry point
+func NewServeCommand() *cobra.Command {
+	var (
+		command   *cobra.Command
+		options   structs.ServerOptions
+		tokenFile string
+
+		getExample = `
+		K8SBOX_API_TOKEN=secret k8sbox serve // serve the REST API and the dashboard on port 8080, open http://localhost:8080
+
+		k8sbox serve --address=:8443 --token-file=/etc/k8sbox/token --tls-cert=tls.crt --tls-key=tls.key // serve the REST API over TLS
+		`
+	)
+	command = &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the REST API and the web dashboard",
+		Long: "Serve a REST API that runs, lists, describes and deletes environments, together with a web dashboard on top of it. " +
+			"Environments are posted as the JSON or toml spec of the Environment custom resource. " +
+			"Runs and deletes are queued as operations and polled at /v1/operations/{id}. " +
+			"Every request must carry the token as a bearer token, the OpenAPI document is served at /openapi.yaml.",
+		Example: getExample,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			handlers.HandleServeCommand(command.Context(), options, tokenFile)
+			return nil
+		},
+	}
+	command.Flags().StringVar(&options.Address, "address", structs.DEFAULT_SERVER_ADDRESS, "The address the server listens on.")
+	command.Flags().StringVar(&tokenFile, "token-file", "", "The file the API token is read from. Defaults to the K8SBOX_API_TOKEN variable.")
+	command.Flags().StringVar(&options.TLSCert, "tls-cert", "", "The certificate file of the server.")
+	command.Flags().StringVar(&options.TLSKey, "tls-key", "", "The private key file of the server.")
+	command.Flags().BoolVar(&options.AllowURLSources, "allow-url-sources", false, "Download the charts and the manifests of the posted environments from their URLs.")
+	return command
+}
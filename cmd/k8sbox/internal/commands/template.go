@@ -0,0 +1,36 @@
+// Package commands is an entry point for every single cobra command available
+package commands
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/twelvee/k8sbox/internal/k8sbox/handlers"
+)
+
+// NewTemplateCommand is template command entry point
+func NewTemplateCommand() *cobra.Command {
+	var (
+		command   *cobra.Command
+		tomlFile  string
+		outputDir string
+
+		getExample = `
+		k8sbox template -f /examples/environments/example_environment.toml // Prints the manifests of every box to the standard output
+
+		k8sbox template -f /examples/environments/example_environment.toml --output-dir ./rendered // Writes the manifests to ./rendered/{EnvironmentID}/{BoxName}/{kind}-{name}.yaml
+		`
+	)
+	command = &cobra.Command{
+		Use:     "template",
+		Short:   "Render the environment locally",
+		Long:    "Render the manifests of the environment with the toml specification without connecting to your k8s cluster.",
+		Example: getExample,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			handlers.HandleTemplateCommand(command.Context(), tomlFile, outputDir)
+			return nil
+		},
+	}
+	command.Flags().StringVarP(&tomlFile, "file", "f", "", "Path toml file specifying the environment to be rendered.")
+	command.Flags().StringVarP(&outputDir, "output-dir", "o", "", "Write the manifests to the directory instead of the standard output.")
+	command.MarkFlagRequired("file")
+	return command
+}
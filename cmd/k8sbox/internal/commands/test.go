@@ -0,0 +1,34 @@
+// Package commands is an entry point for every single cobra command available
+package commands
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/twelvee/k8sbox/internal/k8sbox/handlers"
+)
+
+// NewTestCommand is test command entry point
+func NewTestCommand() *cobra.Command {
+	var (
+		command   *cobra.Command
+		namespace string
+
+		getExample = `
+		k8sbox test environment {EnvironmentID} -n test // will run the helm test hooks of the environment boxes
+
+		k8sbox test env {EnvironmentID} --namespace=default // will run the helm test hooks of the environment boxes
+		`
+	)
+	command = &cobra.Command{
+		Use:     "test",
+		Short:   "Test a resource",
+		Long:    "Run the test hooks (helm.sh/hook: test) of the rolled out environment boxes. Use requires specifying the type of the resource as the first argument.",
+		Example: getExample,
+		Args:    cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			handlers.HandleTestCommand(command.Context(), args[0], args[1], namespace)
+			return nil
+		},
+	}
+	command.Flags().StringVarP(&namespace, "namespace", "n", "default", "The namespace of the environment to be tested.")
+	return command
+}
@@ -0,0 +1,55 @@
+// Package handlers is used to process Cobra commands
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	model "github.com/twelvee/k8sbox/internal/k8sbox/models"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+)
+
+// HandleControllerCommand is the k8sbox controller command handler
+func HandleControllerCommand(context context.Context, options structs.ControllerOptions) {
+	options = getControllerOptions(options)
+	err := model.RunController(context, options)
+	if err != nil {
+		fmt.Println("Failed to run the controller.", err)
+		os.Exit(1)
+	}
+}
+
+// HandleOperatorCommand is the k8sbox operator command handler
+func HandleOperatorCommand(context context.Context, options structs.ControllerOptions) {
+	options = getControllerOptions(options)
+	err := model.RunOperator(context, options)
+	if err != nil {
+		fmt.Println("Failed to run the operator.", err)
+		os.Exit(1)
+	}
+}
+
+// getControllerOptions validates the options and fills the empty ones with defaults
+func getControllerOptions(options structs.ControllerOptions) structs.ControllerOptions {
+	if options.Interval <= 0 {
+		fmt.Println("An invalid interval. Use a positive duration like 30s or 5m.")
+		os.Exit(1)
+	}
+	if len(strings.TrimSpace(options.LeaseNamespace)) == 0 {
+		options.LeaseNamespace = os.Getenv("POD_NAMESPACE")
+	}
+	if len(strings.TrimSpace(options.LeaseNamespace)) == 0 {
+		options.LeaseNamespace = "default"
+	}
+	if len(strings.TrimSpace(options.Identity)) == 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			fmt.Println("Failed to get the host name, set the identity with --id.", err)
+			os.Exit(1)
+		}
+		options.Identity = hostname
+	}
+	return options
+}
@@ -13,7 +13,7 @@ import (
 )
 
 // HandleDeleteCommand is the k8sbox delete command handler
-func HandleDeleteCommand(context context.Context, modelName string, environmentID string, namespace string) {
+func HandleDeleteCommand(context context.Context, modelName string, environmentID string, namespace string, withCRDs bool) {
 	if !slices.Contains(structs.GetEnvironmentAliases(), modelName) {
 		fmt.Printf("An invalid argument. Available arguments: %s\r\n", strings.Join(structs.GetEnvironmentAliases(), ", "))
 		os.Exit(1)
@@ -25,7 +25,7 @@ func HandleDeleteCommand(context context.Context, modelName string, environmentI
 
 	KuberExecutable(context, namespace)
 
-	err := model.DeleteEnvironmentByID(namespace, environmentID)
+	err := model.DeleteEnvironmentByID(namespace, environmentID, withCRDs)
 	if err != nil {
 		fmt.Println("Failed to delete environment.", err)
 	}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/twelvee/k8sbox/internal/k8sbox"
 	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/utils"
 	"k8s.io/utils/strings/slices"
 )
 
@@ -38,6 +39,13 @@ func HandleDescribeCommand(context context.Context, modelName string, envID stri
 			fmt.Println(err.Error())
 			continue
 		}
+		diagnosis, err := k8sbox.GetBoxService().DiagnoseBox(b)
+		if err != nil {
+			fmt.Println("Unable to diagnose the box.")
+			fmt.Println(err.Error())
+		} else if !diagnosis.IsHealthy() {
+			fmt.Println(utils.FormatBoxDiagnosis(diagnosis))
+		}
 		fmt.Println()
 	}
 }
@@ -0,0 +1,46 @@
+// Package handlers is used to process Cobra commands
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	model "github.com/twelvee/k8sbox/internal/k8sbox/models"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+)
+
+// HandleDiffCommand is the k8sbox diff command handler.
+// It exits with 0 when there are no differences, 1 when there are and 2 when something went wrong.
+func HandleDiffCommand(context context.Context, tomlFile string) {
+	diff, err := model.DiffEnvironment(tomlFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to diff environment. ", err)
+		os.Exit(2)
+	}
+
+	rejected := 0
+	for _, o := range diff.Objects {
+		switch o.Action {
+		case structs.ACTION_UNCHANGED:
+			continue
+		case structs.ACTION_REJECT:
+			rejected++
+			color.Red("! %s %s in box %s is rejected: %s", o.Kind, o.Name, o.Box, o.Reason)
+		default:
+			fmt.Print(o.Diff)
+		}
+	}
+	for _, box := range diff.RemovedBoxes {
+		color.Red("- Box %s is removed from the specification", box)
+	}
+
+	if rejected > 0 {
+		os.Exit(2)
+	}
+	if diff.HasChanges() {
+		os.Exit(1)
+	}
+	fmt.Println("No differences found.")
+}
@@ -0,0 +1,36 @@
+// Package handlers is used to process Cobra commands
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	model "github.com/twelvee/k8sbox/internal/k8sbox/models"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"k8s.io/utils/strings/slices"
+)
+
+// HandleExtendCommand is the k8sbox extend command handler
+func HandleExtendCommand(context context.Context, modelName string, environmentID string, duration string, namespace string) {
+	if !slices.Contains(structs.GetEnvironmentAliases(), modelName) {
+		fmt.Printf("An invalid argument. Available arguments: %s\r\n", strings.Join(structs.GetEnvironmentAliases(), ", "))
+		os.Exit(1)
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil || d <= 0 {
+		fmt.Printf("An invalid duration %s. Use a positive duration like 30m or 24h.\r\n", duration)
+		os.Exit(1)
+	}
+
+	KuberExecutable(context, namespace)
+
+	environment, err := model.ExtendEnvironment(namespace, environmentID, d)
+	if err != nil {
+		fmt.Println("Failed to extend environment.", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Environment %s expires at %s.\r\n", environment.ID, formatTimeToTable(environment.ExpiresAt))
+}
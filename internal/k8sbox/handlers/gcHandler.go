@@ -0,0 +1,41 @@
+// Package handlers is used to process Cobra commands
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	model "github.com/twelvee/k8sbox/internal/k8sbox/models"
+)
+
+// HandleGCCommand is the k8sbox gc command handler
+func HandleGCCommand(context context.Context, namespace string, dryRun bool) {
+	expired, err := model.CollectExpiredEnvironments(namespace, dryRun)
+	if err != nil {
+		fmt.Println("Failed to collect expired environments.", err)
+		os.Exit(1)
+	}
+	if len(expired) == 0 {
+		fmt.Println("No expired environments found.")
+		return
+	}
+
+	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
+	columnFmt := color.New(color.FgYellow).SprintfFunc()
+
+	tbl := table.New("ID", "Name", "Namespace", "Boxes", "Expired")
+	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt)
+	for _, environment := range expired {
+		tbl.AddRow(environment.ID, environment.Name, environment.Namespace, formatBoxesToTable(environment.Boxes), formatTimeToTable(environment.ExpiresAt))
+	}
+	tbl.Print()
+
+	if dryRun {
+		fmt.Printf("%d environments would be deleted.\r\n", len(expired))
+		return
+	}
+	fmt.Printf("%d environments deleted.\r\n", len(expired))
+}
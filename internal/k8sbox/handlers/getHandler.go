@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/rodaine/table"
@@ -26,7 +27,7 @@ func HandleGetCommand(context context.Context, modelName string, namespace strin
 	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
 	columnFmt := color.New(color.FgYellow).SprintfFunc()
 
-	tbl := table.New("ID", "Name", "Namespace", "Boxes")
+	tbl := table.New("ID", "Name", "Namespace", "Boxes", "Updated", "Expires", "Status")
 	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt)
 	s := k8sbox.GetStorageService()
 	environments, err := s.GetEnvironments(namespace)
@@ -34,8 +35,13 @@ func HandleGetCommand(context context.Context, modelName string, namespace strin
 		fmt.Println("No environments found.")
 		os.Exit(1)
 	}
+	statuses, err := s.GetStatuses(namespace)
+	if err != nil {
+		fmt.Println("Failed to get the environment statuses.", err)
+		os.Exit(1)
+	}
 	for _, widget := range environments {
-		tbl.AddRow(widget.ID, widget.Name, widget.Namespace, formatBoxesToTable(widget.Boxes))
+		tbl.AddRow(widget.ID, widget.Name, widget.Namespace, formatBoxesToTable(widget.Boxes), formatTimeToTable(widget.UpdatedAt), formatExpiryToTable(widget), formatStatusToTable(statuses, widget))
 	}
 
 	tbl.Print()
@@ -48,3 +54,29 @@ func formatBoxesToTable(boxes []structs.Box) string {
 	}
 	return strings.Join(boxWidget, ", ")
 }
+
+func formatTimeToTable(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func formatExpiryToTable(environment structs.Environment) string {
+	if environment.ExpiresAt.IsZero() {
+		return "never"
+	}
+	if environment.IsExpired(time.Now()) {
+		return "expired"
+	}
+	return formatTimeToTable(environment.ExpiresAt)
+}
+
+// formatStatusToTable shows the status the controller observed for the environment revision
+func formatStatusToTable(statuses map[string]structs.EnvironmentStatus, environment structs.Environment) string {
+	status, ok := statuses[environment.ID]
+	if !ok || status.Revision != environment.Revision {
+		return "-"
+	}
+	return string(status.Phase)
+}
@@ -0,0 +1,52 @@
+// Package handlers is used to process Cobra commands
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	model "github.com/twelvee/k8sbox/internal/k8sbox/models"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"k8s.io/utils/strings/slices"
+)
+
+// historyMessageLength limits the length of the failure messages shown in the history table
+const historyMessageLength = 60
+
+// HandleHistoryCommand is the k8sbox history command handler
+func HandleHistoryCommand(context context.Context, modelName string, environmentID string, namespace string) {
+	if !slices.Contains(structs.GetEnvironmentAliases(), modelName) {
+		fmt.Printf("An invalid argument. Available arguments: %s\r\n", strings.Join(structs.GetEnvironmentAliases(), ", "))
+		os.Exit(1)
+	}
+
+	KuberExecutable(context, namespace)
+
+	revisions, err := model.GetEnvironmentHistory(namespace, environmentID)
+	if err != nil {
+		fmt.Println("Failed to get the environment history.", err)
+		os.Exit(1)
+	}
+	if len(revisions) == 0 {
+		fmt.Println("No revisions found.")
+		os.Exit(1)
+	}
+
+	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
+	columnFmt := color.New(color.FgYellow).SprintfFunc()
+
+	tbl := table.New("Revision", "Updated", "Status", "Description", "Boxes", "Invoker", "Message")
+	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt)
+	for _, r := range revisions {
+		message := strings.ReplaceAll(r.Message, "\n\r", " ")
+		if len(message) > historyMessageLength {
+			message = message[:historyMessageLength] + "..."
+		}
+		tbl.AddRow(r.Number, r.Timestamp.Format("2006-01-02 15:04:05"), r.Status, r.Description, formatBoxesToTable(r.Environment.Boxes), r.Invoker, message)
+	}
+	tbl.Print()
+}
@@ -0,0 +1,55 @@
+// Package handlers is used to process Cobra commands
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	model "github.com/twelvee/k8sbox/internal/k8sbox/models"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"k8s.io/utils/strings/slices"
+)
+
+// HandlePruneCommand is the k8sbox prune command handler
+func HandlePruneCommand(context context.Context, modelName string, environmentID string, namespace string, dryRun bool) {
+	if !slices.Contains(structs.GetEnvironmentAliases(), modelName) {
+		fmt.Printf("An invalid argument. Available arguments: %s\r\n", strings.Join(structs.GetEnvironmentAliases(), ", "))
+		os.Exit(1)
+	}
+	if len(strings.TrimSpace(environmentID)) == 0 {
+		fmt.Println("The resource could not be pruned. None of the flags pointing to the resource are present.")
+		os.Exit(1)
+	}
+
+	KuberExecutable(context, namespace)
+
+	reports, err := model.PruneEnvironmentByID(namespace, environmentID, dryRun)
+	if err != nil {
+		fmt.Println("Failed to prune environment.", err)
+		os.Exit(1)
+	}
+	if len(reports) == 0 {
+		fmt.Println("Nothing to prune.")
+		return
+	}
+
+	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
+	columnFmt := color.New(color.FgYellow).SprintfFunc()
+
+	tbl := table.New("Box", "Kind", "Namespace", "Name", "Action", "Reason")
+	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt)
+	for _, report := range reports {
+		tbl.AddRow(report.Box, report.Kind, report.Namespace, report.Name, report.Action, report.Reason)
+	}
+	tbl.Print()
+
+	if dryRun {
+		fmt.Printf("%d objects would be deleted.\r\n", len(reports))
+		return
+	}
+	fmt.Printf("%d objects deleted.\r\n", len(reports))
+}
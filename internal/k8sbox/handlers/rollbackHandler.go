@@ -0,0 +1,33 @@
+// Package handlers is used to process Cobra commands
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	model "github.com/twelvee/k8sbox/internal/k8sbox/models"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"k8s.io/utils/strings/slices"
+)
+
+// HandleRollbackCommand is the k8sbox rollback command handler
+func HandleRollbackCommand(context context.Context, modelName string, environmentID string, namespace string, revision int, options structs.DeployOptions) {
+	if !slices.Contains(structs.GetEnvironmentAliases(), modelName) {
+		fmt.Printf("An invalid argument. Available arguments: %s\r\n", strings.Join(structs.GetEnvironmentAliases(), ", "))
+		os.Exit(1)
+	}
+	if revision < 0 {
+		fmt.Println("The revision must be a positive number.")
+		os.Exit(1)
+	}
+
+	KuberExecutable(context, namespace)
+
+	err := model.RollbackEnvironment(namespace, environmentID, revision, options)
+	if err != nil {
+		fmt.Println("Failed to roll back environment.", err)
+		os.Exit(1)
+	}
+}
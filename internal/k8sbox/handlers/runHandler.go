@@ -5,15 +5,55 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
 	model "github.com/twelvee/k8sbox/internal/k8sbox/models"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"k8s.io/utils/strings/slices"
 )
 
 // HandleRunCommand is the k8sbox run command handler
-func HandleRunCommand(context context.Context, tomlFile string) {
-	err := model.RunEnvironment(tomlFile)
+func HandleRunCommand(context context.Context, tomlFile string, options structs.DeployOptions) {
+	if options.DryRun != structs.DRY_RUN_NONE {
+		handleDryRun(tomlFile, options.DryRun)
+		return
+	}
+	err := model.RunEnvironment(tomlFile, options)
 	if err != nil {
 		fmt.Println("Failed to run environment. ", err)
 		os.Exit(1)
 	}
 }
+
+func handleDryRun(tomlFile string, strategy structs.DryRunStrategy) {
+	if !slices.Contains(structs.GetDryRunStrategies(), string(strategy)) {
+		fmt.Printf("An invalid dry run strategy. Available strategies: %s\r\n", strings.Join(structs.GetDryRunStrategies(), ", "))
+		os.Exit(1)
+	}
+	reports, err := model.DryRunEnvironment(tomlFile, strategy)
+	if err != nil {
+		fmt.Println("Failed to run environment. ", err)
+		os.Exit(1)
+	}
+
+	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
+	columnFmt := color.New(color.FgYellow).SprintfFunc()
+
+	tbl := table.New("Box", "Kind", "Namespace", "Name", "Action", "Reason")
+	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt)
+	rejected := 0
+	for _, report := range reports {
+		if report.Action == structs.ACTION_REJECT {
+			rejected++
+		}
+		tbl.AddRow(report.Box, report.Kind, report.Namespace, report.Name, report.Action, report.Reason)
+	}
+	tbl.Print()
+
+	if rejected > 0 {
+		fmt.Printf("%d of %d objects would be rejected.\r\n", rejected, len(reports))
+		os.Exit(1)
+	}
+}
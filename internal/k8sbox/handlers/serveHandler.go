@@ -0,0 +1,39 @@
+// Package handlers is used to process Cobra commands
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	model "github.com/twelvee/k8sbox/internal/k8sbox/models"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+)
+
+// HandleServeCommand is the k8sbox serve command handler
+func HandleServeCommand(context context.Context, options structs.ServerOptions, tokenFile string) {
+	options.Token = os.Getenv(structs.SERVER_TOKEN_VARIABLE)
+	if len(tokenFile) > 0 {
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			fmt.Println("Failed to read the token file.", err)
+			os.Exit(1)
+		}
+		options.Token = string(token)
+	}
+	options.Token = strings.TrimSpace(options.Token)
+	if len(options.Token) == 0 {
+		fmt.Printf("The API token is missing. Set the %s variable or use --token-file.\r\n", structs.SERVER_TOKEN_VARIABLE)
+		os.Exit(1)
+	}
+	if (len(options.TLSCert) == 0) != (len(options.TLSKey) == 0) {
+		fmt.Println("Use --tls-cert and --tls-key together.")
+		os.Exit(1)
+	}
+	err := model.RunServer(context, options)
+	if err != nil {
+		fmt.Println("Failed to serve the API.", err)
+		os.Exit(1)
+	}
+}
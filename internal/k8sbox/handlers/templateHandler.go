@@ -0,0 +1,19 @@
+// Package handlers is used to process Cobra commands
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	model "github.com/twelvee/k8sbox/internal/k8sbox/models"
+)
+
+// HandleTemplateCommand is the k8sbox template command handler
+func HandleTemplateCommand(context context.Context, tomlFile string, outputDir string) {
+	err := model.TemplateEnvironment(tomlFile, outputDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to render environment. ", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,29 @@
+// Package handlers is used to process Cobra commands
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	model "github.com/twelvee/k8sbox/internal/k8sbox/models"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"k8s.io/utils/strings/slices"
+)
+
+// HandleTestCommand is the k8sbox test command handler
+func HandleTestCommand(context context.Context, modelName string, environmentID string, namespace string) {
+	if !slices.Contains(structs.GetEnvironmentAliases(), modelName) {
+		fmt.Printf("An invalid argument. Available arguments: %s\r\n", strings.Join(structs.GetEnvironmentAliases(), ", "))
+		os.Exit(1)
+	}
+
+	KuberExecutable(context, namespace)
+
+	err := model.TestEnvironmentByID(namespace, environmentID)
+	if err != nil {
+		fmt.Println("Failed to test environment.", err)
+		os.Exit(1)
+	}
+}
@@ -27,6 +27,21 @@ func GetStorageService() structs.StorageService {
 	return services.NewStorageService()
 }
 
+// GetControllerService will create and return a new ControllerService
+func GetControllerService() structs.ControllerService {
+	return services.NewControllerService()
+}
+
+// GetOperatorService will create and return a new OperatorService
+func GetOperatorService() structs.OperatorService {
+	return services.NewOperatorService()
+}
+
+// GetServerService will create and return a new ServerService
+func GetServerService() structs.ServerService {
+	return services.NewServerService()
+}
+
 // GetTomlFormatter will create and return a new TomlFormatter
 func GetTomlFormatter() formatters.TomlFormatter {
 	return formatters.NewTomlFormatter()
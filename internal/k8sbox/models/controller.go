@@ -0,0 +1,55 @@
+// Package model is used as an model entry point
+package model
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/twelvee/k8sbox/internal/k8sbox"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+)
+
+// RunController will reconcile the saved environments of the cluster until the context is done
+func RunController(ctx context.Context, options structs.ControllerOptions) error {
+	err := k8sbox.GetEnvironmentService().ConnectToCluster(options.LeaseNamespace)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Starting the controller %s (lease %s/%s).\r\n", options.Identity, options.LeaseNamespace, options.LeaseName)
+	err = k8sbox.GetControllerService().Run(ctx, options)
+	if err != nil {
+		return err
+	}
+	fmt.Println("The controller is stopped.")
+	return nil
+}
+
+// RunOperator will reconcile the Environment custom resources of the cluster until the context is done
+func RunOperator(ctx context.Context, options structs.ControllerOptions) error {
+	err := k8sbox.GetEnvironmentService().ConnectToCluster(options.LeaseNamespace)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Starting the operator %s (lease %s/%s).\r\n", options.Identity, options.LeaseNamespace, options.LeaseName)
+	err = k8sbox.GetOperatorService().Run(ctx, options)
+	if err != nil {
+		return err
+	}
+	fmt.Println("The operator is stopped.")
+	return nil
+}
+
+// RunServer will serve the REST API until the context is done
+func RunServer(ctx context.Context, options structs.ServerOptions) error {
+	err := k8sbox.GetEnvironmentService().ConnectToCluster("")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Serving the k8sbox API on %s.\r\n", options.Address)
+	err = k8sbox.GetServerService().Run(ctx, options)
+	if err != nil {
+		return err
+	}
+	fmt.Println("The server is stopped.")
+	return nil
+}
@@ -11,61 +11,172 @@ import (
 	"github.com/briandowns/spinner"
 	"github.com/twelvee/k8sbox/internal/k8sbox"
 	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/utils"
 	"k8s.io/utils/strings/slices"
 )
 
 var s = spinner.New(spinner.CharSets[21], 100*time.Millisecond)
 
+// stepFailureExitCode is the exit code of a failed step
+var stepFailureExitCode = 1
+
 // RunEnvironment will prepare and deploy environment to your k8s cluster
-func RunEnvironment(tomlFile string) error {
+func RunEnvironment(tomlFile string, options structs.DeployOptions) error {
 	start := time.Now()
 	s.Start()
-	environment := lookForEnvironmentStep(tomlFile)
-	if len(strings.TrimSpace(environment.LoadBoxesFrom)) != 0 {
-		loadBoxesStep(&environment)
-	}
-	expandEnvironmentVariablesStep(&environment)
-	expandBoxVariablesStep(&environment)
-	validateEnvironmentStep(&environment)
-	validateBoxesStep(&environment)
+	environment := prepareEnvironmentSteps(tomlFile)
 	err := k8sbox.GetEnvironmentService().PrepareToWorkWithNamespace(environment.Namespace)
 	if err != nil {
 		return err
 	}
-	removeLegacyEnvironmentStep(&environment)
-	deployEnvironmentStep(&environment)
+	if !options.Apply && !options.Atomic {
+		// an atomic deploy replaces the previous environment itself, so it can install it again on failure
+		options.Previous = removeLegacyEnvironmentStep(&environment)
+	}
+	deployEnvironmentStep(&environment, options)
 	s.Stop()
 	fmt.Println("Alright, we're done here!")
 	fmt.Printf("It took %.2fs.\r\n", time.Since(start).Seconds())
 	return nil
 }
 
+// DryRunEnvironment will prepare the environment and report what deploying it would do without persisting anything
+func DryRunEnvironment(tomlFile string, strategy structs.DryRunStrategy) ([]structs.ObjectReport, error) {
+	s.Start()
+	environment := prepareEnvironmentSteps(tomlFile)
+	if strategy == structs.DRY_RUN_SERVER {
+		err := k8sbox.GetEnvironmentService().ConnectToCluster(environment.Namespace)
+		if err != nil {
+			s.Stop()
+			return nil, err
+		}
+	}
+	reports := dryRunEnvironmentStep(&environment, strategy)
+	s.Stop()
+	return reports, nil
+}
+
+// DiffEnvironment will prepare the environment and compare it with the live objects and the saved environment state
+func DiffEnvironment(tomlFile string) (structs.EnvironmentDiff, error) {
+	// exit code 1 is reserved for the found differences
+	stepFailureExitCode = 2
+	s.Start()
+	environment := prepareEnvironmentSteps(tomlFile)
+	err := k8sbox.GetEnvironmentService().ConnectToCluster(environment.Namespace)
+	if err != nil {
+		s.Stop()
+		return structs.EnvironmentDiff{}, err
+	}
+	diff := diffEnvironmentStep(&environment)
+	s.Stop()
+	return diff, nil
+}
+
 // DeleteEnvironmentByID will delete saved environment by environmentID
-func DeleteEnvironmentByID(namespace string, environmentID string) error {
+func DeleteEnvironmentByID(namespace string, environmentID string, withCRDs bool) error {
 	environment, err := k8sbox.GetStorageService().GetEnvironment(namespace, environmentID)
 	if err != nil {
 		return err
 	}
-	return deleteEnvironment(environment)
+	return deleteEnvironment(environment, withCRDs)
+}
+
+// TestEnvironmentByID will run the test hooks of the saved environment
+func TestEnvironmentByID(namespace string, environmentID string) error {
+	start := time.Now()
+	environment, err := k8sbox.GetStorageService().GetEnvironment(namespace, environmentID)
+	if err != nil {
+		return err
+	}
+	s.Start()
+	testEnvironmentStep(environment)
+	s.Stop()
+	fmt.Println("Alright, every test has passed!")
+	fmt.Printf("It took %.2fs.\r\n", time.Since(start).Seconds())
+	return nil
+}
+
+// GetEnvironmentHistory will return the saved revisions of the environment
+func GetEnvironmentHistory(namespace string, environmentID string) ([]structs.Revision, error) {
+	return k8sbox.GetStorageService().GetRevisions(namespace, environmentID)
+}
+
+// RollbackEnvironment will apply the render of an earlier revision to the environment
+func RollbackEnvironment(namespace string, environmentID string, revision int, options structs.DeployOptions) error {
+	start := time.Now()
+	s.Start()
+	environment := rollbackEnvironmentStep(namespace, environmentID, revision, options)
+	s.Stop()
+	fmt.Printf("Environment %s is rolled back as revision %d.\r\n", environment.ID, environment.Revision)
+	fmt.Printf("It took %.2fs.\r\n", time.Since(start).Seconds())
+	return nil
+}
+
+// PruneEnvironmentByID will delete the labelled objects of the environment that are not in its saved render
+func PruneEnvironmentByID(namespace string, environmentID string, dryRun bool) ([]structs.ObjectReport, error) {
+	s.Start()
+	reports := pruneEnvironmentStep(namespace, environmentID, dryRun)
+	s.Stop()
+	return reports, nil
+}
+
+// CollectExpiredEnvironments will delete every environment whose TTL has passed. An empty namespace stands for all namespaces.
+func CollectExpiredEnvironments(namespace string, dryRun bool) ([]structs.Environment, error) {
+	err := k8sbox.GetEnvironmentService().ConnectToCluster(namespace)
+	if err != nil {
+		return nil, err
+	}
+	s.Start()
+	expired := findExpiredEnvironmentsStep(namespace)
+	if !dryRun {
+		for i := range expired {
+			deleteEnvironmentStep(&expired[i])
+			deleteEnvironmentHistoryStep(&expired[i])
+		}
+	}
+	s.Stop()
+	return expired, nil
+}
+
+// ExtendEnvironment will push the expiry of the saved environment back
+func ExtendEnvironment(namespace string, environmentID string, duration time.Duration) (*structs.Environment, error) {
+	return k8sbox.GetEnvironmentService().ExtendEnvironment(namespace, environmentID, duration)
 }
 
 // DeleteEnvironmentByTomlFile will delete saved environment by initial toml file
-func DeleteEnvironmentByTomlFile(namespace string, tomlFile string) error {
+func DeleteEnvironmentByTomlFile(namespace string, tomlFile string, withCRDs bool) error {
 	environment := lookForEnvironmentStep(tomlFile)
-	return deleteEnvironment(&environment)
+	return deleteEnvironment(&environment, withCRDs)
 }
 
-func deleteEnvironment(environment *structs.Environment) error {
+func deleteEnvironment(environment *structs.Environment, withCRDs bool) error {
 	start := time.Now()
 	expandEnvironmentVariablesStep(environment)
 	expandBoxVariablesStep(environment)
 	deleteEnvironmentStep(environment)
+	if withCRDs {
+		deleteEnvironmentCRDsStep(environment)
+	}
+	deleteEnvironmentHistoryStep(environment)
 
 	fmt.Println("Alright, we're done here!")
 	fmt.Printf("It took %.2fs.\r\n", time.Since(start).Seconds())
 	return nil
 }
 
+// prepareEnvironmentSteps loads, expands, validates and renders the environment
+func prepareEnvironmentSteps(tomlFile string) structs.Environment {
+	environment := lookForEnvironmentStep(tomlFile)
+	if len(strings.TrimSpace(environment.LoadBoxesFrom)) != 0 {
+		loadBoxesStep(&environment)
+	}
+	expandEnvironmentVariablesStep(&environment)
+	expandBoxVariablesStep(&environment)
+	validateEnvironmentStep(&environment)
+	validateBoxesStep(&environment)
+	return environment
+}
+
 func lookForEnvironmentStep(tomlFile string) structs.Environment {
 	s.Suffix = " Looking for the environment..."
 	environment, err := k8sbox.GetTomlFormatter().GetEnvironmentFromToml(tomlFile)
@@ -73,7 +184,7 @@ func lookForEnvironmentStep(tomlFile string) structs.Environment {
 		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
 		s.Stop()
 		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
-		os.Exit(1)
+		os.Exit(stepFailureExitCode)
 	}
 	s.Suffix = strings.Join([]string{s.Suffix, "OK"}, " ")
 	return environment
@@ -86,20 +197,20 @@ func loadBoxesStep(environment *structs.Environment) {
 		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
 		s.Stop()
 		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
-		os.Exit(1)
+		os.Exit(stepFailureExitCode)
 	}
 	if !slices.Contains(structs.GetAvailableDownloadSchemes(), u.Scheme) {
 		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
 		s.Stop()
 		fmt.Fprintf(os.Stderr, "Failed. Available load_boxes_from scheme is %s\n\r", strings.Join(structs.GetAvailableDownloadSchemes(), ", "))
-		os.Exit(1)
+		os.Exit(stepFailureExitCode)
 	}
 	newEnvironment, err := k8sbox.GetTomlFormatter().GetEnvironmentViaHTTP(environment.LoadBoxesFrom, environment.LoadBoxesHeaders)
 	if err != nil {
 		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
 		s.Stop()
 		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
-		os.Exit(1)
+		os.Exit(stepFailureExitCode)
 	}
 
 	environment.Boxes = newEnvironment.Boxes
@@ -124,7 +235,7 @@ func validateEnvironmentStep(environment *structs.Environment) {
 		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
 		s.Stop()
 		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
-		os.Exit(1)
+		os.Exit(stepFailureExitCode)
 	}
 	s.Suffix = strings.Join([]string{s.Suffix, "OK"}, " ")
 }
@@ -136,7 +247,7 @@ func validateBoxesStep(environment *structs.Environment) {
 		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
 		s.Stop()
 		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
-		os.Exit(1)
+		os.Exit(stepFailureExitCode)
 	}
 
 	for i := range environment.Boxes {
@@ -145,36 +256,84 @@ func validateBoxesStep(environment *structs.Environment) {
 			s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
 			s.Stop()
 			fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
-			os.Exit(1)
+			os.Exit(stepFailureExitCode)
 		}
 	}
 	s.Suffix = strings.Join([]string{s.Suffix, "OK"}, " ")
 }
 
-func removeLegacyEnvironmentStep(environment *structs.Environment) {
+// removeLegacyEnvironmentStep deletes the saved environment and returns it, so the deploy keeps its creation time and expiry
+func removeLegacyEnvironmentStep(environment *structs.Environment) *structs.Environment {
 	saved, _ := k8sbox.GetStorageService().IsEnvironmentSaved(*environment)
 	if !saved {
-		return
+		return nil
 	}
 	s.Suffix = " Deleting previous environment..."
-	err := k8sbox.GetEnvironmentService().DeleteEnvironment(environment)
+	previous, err := k8sbox.GetStorageService().GetEnvironment(environment.Namespace, environment.ID)
+	if err == nil {
+		err = k8sbox.GetEnvironmentService().DeleteEnvironment(environment)
+	}
 	if err != nil {
 		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
 		s.Stop()
 		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
-		os.Exit(1)
+		os.Exit(stepFailureExitCode)
 	}
 	s.Suffix = strings.Join([]string{s.Suffix, "OK"}, " ")
+	return previous
 }
 
-func deployEnvironmentStep(environment *structs.Environment) {
+func deployEnvironmentStep(environment *structs.Environment, options structs.DeployOptions) {
 	s.Suffix = " Deploying..."
-	err := k8sbox.GetEnvironmentService().DeployEnvironment(environment)
+	if options.Apply {
+		s.Suffix = " Applying..."
+	}
+	options.Progress = showReadinessProgress
+	err := k8sbox.GetEnvironmentService().DeployEnvironment(environment, options)
 	if err != nil {
 		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
 		s.Stop()
 		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
-		os.Exit(1)
+		if options.Wait && !options.Atomic {
+			// an atomic deploy explains the failure before rolling the objects back
+			printEnvironmentDiagnosis(environment)
+		}
+		os.Exit(stepFailureExitCode)
+	}
+	s.Suffix = strings.Join([]string{s.Suffix, "OK"}, " ")
+}
+
+// showReadinessProgress shows the number of ready objects of every box in the spinner
+func showReadinessProgress(states []structs.BoxReadiness) {
+	var progress []string
+	for _, state := range states {
+		progress = append(progress, fmt.Sprintf("%s %d/%d", state.Box, state.Ready, state.Total))
+	}
+	s.Suffix = fmt.Sprintf(" Waiting for readiness... %s", strings.Join(progress, ", "))
+}
+
+// printEnvironmentDiagnosis explains why the environment boxes are unhealthy
+func printEnvironmentDiagnosis(environment *structs.Environment) {
+	for _, box := range environment.Boxes {
+		diagnosis, err := k8sbox.GetBoxService().DiagnoseBox(box)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to diagnose box %s: %s\n\r", box.Name, err)
+			continue
+		}
+		if !diagnosis.IsHealthy() {
+			fmt.Fprintf(os.Stderr, "%s\n\r", utils.FormatBoxDiagnosis(diagnosis))
+		}
+	}
+}
+
+func testEnvironmentStep(environment *structs.Environment) {
+	s.Suffix = " Running tests..."
+	err := k8sbox.GetEnvironmentService().TestEnvironment(environment)
+	if err != nil {
+		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
+		s.Stop()
+		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
+		os.Exit(stepFailureExitCode)
 	}
 	s.Suffix = strings.Join([]string{s.Suffix, "OK"}, " ")
 }
@@ -186,7 +345,97 @@ func deleteEnvironmentStep(environment *structs.Environment) {
 		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
 		s.Stop()
 		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
-		os.Exit(1)
+		os.Exit(stepFailureExitCode)
+	}
+	s.Suffix = strings.Join([]string{s.Suffix, "OK"}, " ")
+}
+
+func pruneEnvironmentStep(namespace string, environmentID string, dryRun bool) []structs.ObjectReport {
+	s.Suffix = " Looking for leftovers..."
+	reports, err := k8sbox.GetEnvironmentService().PruneEnvironment(namespace, environmentID, dryRun)
+	if err != nil {
+		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
+		s.Stop()
+		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
+		os.Exit(stepFailureExitCode)
+	}
+	s.Suffix = strings.Join([]string{s.Suffix, "OK"}, " ")
+	return reports
+}
+
+func findExpiredEnvironmentsStep(namespace string) []structs.Environment {
+	s.Suffix = " Looking for expired environments..."
+	expired, err := k8sbox.GetEnvironmentService().GetExpiredEnvironments(namespace)
+	if err != nil {
+		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
+		s.Stop()
+		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
+		os.Exit(stepFailureExitCode)
+	}
+	s.Suffix = strings.Join([]string{s.Suffix, "OK"}, " ")
+	return expired
+}
+
+func rollbackEnvironmentStep(namespace string, environmentID string, revision int, options structs.DeployOptions) *structs.Environment {
+	s.Suffix = " Rolling back..."
+	options.Progress = showReadinessProgress
+	environment, err := k8sbox.GetEnvironmentService().RollbackEnvironment(namespace, environmentID, revision, options)
+	if err != nil {
+		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
+		s.Stop()
+		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
+		os.Exit(stepFailureExitCode)
+	}
+	s.Suffix = strings.Join([]string{s.Suffix, "OK"}, " ")
+	return environment
+}
+
+func deleteEnvironmentHistoryStep(environment *structs.Environment) {
+	s.Suffix = " Deleting history..."
+	err := k8sbox.GetStorageService().DeleteRevisions(environment.Namespace, environment.ID)
+	if err != nil {
+		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
+		s.Stop()
+		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
+		os.Exit(stepFailureExitCode)
+	}
+	s.Suffix = strings.Join([]string{s.Suffix, "OK"}, " ")
+}
+
+func deleteEnvironmentCRDsStep(environment *structs.Environment) {
+	s.Suffix = " Deleting CRDs..."
+	err := k8sbox.GetEnvironmentService().DeleteEnvironmentCRDs(environment)
+	if err != nil {
+		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
+		s.Stop()
+		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
+		os.Exit(stepFailureExitCode)
+	}
+	s.Suffix = strings.Join([]string{s.Suffix, "OK"}, " ")
+}
+
+func dryRunEnvironmentStep(environment *structs.Environment, strategy structs.DryRunStrategy) []structs.ObjectReport {
+	s.Suffix = " Running a dry run..."
+	reports, err := k8sbox.GetEnvironmentService().DryRunEnvironment(environment, strategy)
+	if err != nil {
+		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
+		s.Stop()
+		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
+		os.Exit(stepFailureExitCode)
+	}
+	s.Suffix = strings.Join([]string{s.Suffix, "OK"}, " ")
+	return reports
+}
+
+func diffEnvironmentStep(environment *structs.Environment) structs.EnvironmentDiff {
+	s.Suffix = " Comparing with the cluster..."
+	diff, err := k8sbox.GetEnvironmentService().DiffEnvironment(environment)
+	if err != nil {
+		s.Suffix = strings.Join([]string{s.Suffix, "FAIL"}, " ")
+		s.Stop()
+		fmt.Fprintf(os.Stderr, "Failed: \n\r%s\n\r", err)
+		os.Exit(stepFailureExitCode)
 	}
 	s.Suffix = strings.Join([]string{s.Suffix, "OK"}, " ")
+	return diff
 }
@@ -0,0 +1,92 @@
+// Package model is used as an model entry point
+package model
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/utils"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// TemplateEnvironment will render the environment without connecting to your k8s cluster.
+// Manifests are printed to stdout or written to outputDir/environment/box/kind-name.yaml
+func TemplateEnvironment(tomlFile string, outputDir string) error {
+	environment := prepareEnvironmentSteps(tomlFile)
+
+	if len(strings.TrimSpace(outputDir)) == 0 {
+		return printEnvironmentManifests(environment)
+	}
+	return writeEnvironmentManifests(environment, outputDir)
+}
+
+func printEnvironmentManifests(environment structs.Environment) error {
+	for _, box := range environment.Boxes {
+		manifests, err := getTemplateManifests(box)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("# Box: %s (namespace: %s)\n", box.Name, box.Namespace)
+		for _, manifest := range manifests {
+			fmt.Println("---")
+			fmt.Println(strings.TrimSpace(manifest))
+		}
+	}
+	return nil
+}
+
+// getTemplateManifests returns every box manifest, hooks go last
+func getTemplateManifests(box structs.Box) ([]string, error) {
+	hooks, err := utils.GetHookManifests(box.HelmRender)
+	if err != nil {
+		return nil, err
+	}
+	return append(utils.GetBoxManifests(box), hooks...), nil
+}
+
+func writeEnvironmentManifests(environment structs.Environment, outputDir string) error {
+	for _, box := range environment.Boxes {
+		boxDir := filepath.Join(outputDir, environment.ID, box.Name)
+		err := os.MkdirAll(boxDir, 0750)
+		if err != nil {
+			return err
+		}
+		written := make(map[string]int)
+		manifests, err := getTemplateManifests(box)
+		if err != nil {
+			return err
+		}
+		for _, manifest := range manifests {
+			fileName, err := getManifestFileName(manifest)
+			if err != nil {
+				return err
+			}
+			written[fileName]++
+			if written[fileName] > 1 {
+				fileName = fmt.Sprintf("%s-%d", fileName, written[fileName])
+			}
+			err = os.WriteFile(filepath.Join(boxDir, fileName+".yaml"), []byte(strings.TrimSpace(manifest)+"\n"), 0644)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Box %s: %d manifests written to %s\n", box.Name, len(manifests), boxDir)
+	}
+	return nil
+}
+
+func getManifestFileName(manifest string) (string, error) {
+	obj, err := utils.CreateRuntimeObject(manifest)
+	if err != nil {
+		return "", err
+	}
+	name, err := meta.NewAccessor().Name(obj)
+	if err != nil {
+		return "", err
+	}
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	return strings.ToLower(strings.Join([]string{kind, name}, "-")), nil
+}
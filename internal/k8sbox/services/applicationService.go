@@ -5,11 +5,20 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
 	"github.com/twelvee/k8sbox/pkg/k8sbox/utils"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -31,6 +40,7 @@ func NewApplicationService() structs.ApplicationService {
 		DescribeHPA:                   describeHPA,
 		DescribeService:               describeService,
 		DescribeIngress:               describeIngress,
+		DescribeCustomResource:        describeCustomResource,
 	}
 }
 
@@ -148,7 +158,7 @@ func describeDaemonSet(k8sclient *kubernetes.Clientset, namespace string, name s
 	if len(ds.Spec.Template.Spec.Containers) > 0 {
 		result = append(result, "Containers:")
 		for _, container := range ds.Spec.Template.Spec.Containers {
-			result = append(result, fmt.Sprintf("%s (image: %s)", &container.Name, container.Image))
+			result = append(result, fmt.Sprintf("%s (image: %s)", container.Name, container.Image))
 		}
 	}
 	fmt.Println(strings.Join(result, "\r\n"))
@@ -185,7 +195,9 @@ func describeCronjob(k8sclient *kubernetes.Clientset, namespace string, name str
 	var result []string
 	result = append(result, fmt.Sprintf("Cron Job %s:", cj.Name))
 	result = append(result, fmt.Sprintf("Schedule: %s", cj.Spec.Schedule))
-	result = append(result, fmt.Sprintf("Time zone: %s", cj.Spec.TimeZone))
+	if cj.Spec.TimeZone != nil {
+		result = append(result, fmt.Sprintf("Time zone: %s", *cj.Spec.TimeZone))
+	}
 
 	if len(cj.Spec.JobTemplate.Spec.Template.Spec.Containers) > 0 {
 		result = append(result, "Containers:")
@@ -244,11 +256,52 @@ func describeIngress(k8sclient *kubernetes.Clientset, namespace string, name str
 	return nil
 }
 
+func describeCustomResource(obj runtime.Object, namespace string) error {
+	mapping, restHelper, err := clients.restHelper(obj)
+	if err != nil {
+		return err
+	}
+	name, err := meta.NewAccessor().Name(obj)
+	if err != nil {
+		return err
+	}
+	live, err := restHelper.Get(namespace, name)
+	if err != nil {
+		return err
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(live)
+	if err != nil {
+		return err
+	}
+	var result []string
+	result = append(result, fmt.Sprintf("%s %s:", mapping.GroupVersionKind.Kind, name))
+	result = append(result, fmt.Sprintf("API version: %s", mapping.GroupVersionKind.GroupVersion().String()))
+	conditions, _, _ := unstructured.NestedSlice(content, "status", "conditions")
+	if len(conditions) > 0 {
+		result = append(result, "Conditions:")
+		for _, c := range conditions {
+			condition, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			result = append(result, fmt.Sprintf("%v: %v %v", condition["type"], condition["status"], condition["message"]))
+		}
+	}
+	fmt.Println(strings.Join(result, "\r\n"))
+	return nil
+}
+
 func validateApplications(applications []structs.Application) []string {
 	var messages []string
+	// the renders of the box are keyed by the application name
+	names := make(map[string]int)
 	for index, application := range applications {
 		if len(application.Name) == 0 {
 			messages = append(messages, fmt.Sprintf("--> Application %d: Name is missing", index))
+		} else if other, ok := names[application.Name]; ok {
+			messages = append(messages, fmt.Sprintf("--> Application %d: Name %s is already used by application %d", index, application.Name, other))
+		} else {
+			names[application.Name] = index
 		}
 
 		if len(strings.TrimSpace(application.Chart)) == 0 {
@@ -272,3 +325,460 @@ func ExpandApplications(applications []structs.Application) []structs.Applicatio
 	}
 	return newApplications
 }
+
+// objectReadiness is a readiness state of a single k8s object
+type objectReadiness struct {
+	ready bool
+	// failed objects will never become ready
+	failed bool
+	reason string
+}
+
+func notReady(format string, a ...interface{}) objectReadiness {
+	return objectReadiness{reason: fmt.Sprintf(format, a...)}
+}
+
+// isWaitableKind checks if k8sbox knows how to wait for the kind to become ready
+func isWaitableKind(kind string) bool {
+	switch kind {
+	case structs.KIND_DEPLOYMENT, structs.KIND_STATEFUL_SET, structs.KIND_DAEMON_SET, structs.KIND_JOB,
+		structs.KIND_PVC, structs.KIND_SERVICE, structs.KIND_INGRESS:
+		return true
+	}
+	return false
+}
+
+// getObjectReadiness checks if the object of a waitable kind is ready
+func getObjectReadiness(k8sclient *kubernetes.Clientset, kind string, namespace string, name string) objectReadiness {
+	var readiness objectReadiness
+	var err error
+	switch kind {
+	case structs.KIND_DEPLOYMENT:
+		readiness, err = getDeploymentReadiness(k8sclient, namespace, name)
+	case structs.KIND_STATEFUL_SET:
+		readiness, err = getStatefulSetReadiness(k8sclient, namespace, name)
+	case structs.KIND_DAEMON_SET:
+		readiness, err = getDaemonSetReadiness(k8sclient, namespace, name)
+	case structs.KIND_JOB:
+		readiness, err = getJobReadiness(k8sclient, namespace, name)
+	case structs.KIND_PVC:
+		readiness, err = getPVCReadiness(k8sclient, namespace, name)
+	case structs.KIND_SERVICE:
+		readiness, err = getServiceReadiness(k8sclient, namespace, name)
+	case structs.KIND_INGRESS:
+		readiness, err = getIngressReadiness(k8sclient, namespace, name)
+	default:
+		return objectReadiness{ready: true}
+	}
+	if k8serrors.IsNotFound(err) {
+		return notReady("not found")
+	}
+	if err != nil {
+		// the API may be temporarily unavailable, so the object is checked again on the next poll
+		return notReady("%s", err)
+	}
+	return readiness
+}
+
+func getDeploymentReadiness(k8sclient *kubernetes.Clientset, namespace string, name string) (objectReadiness, error) {
+	d, err := k8sclient.AppsV1().Deployments(namespace).Get(context.Background(), name, v1.GetOptions{})
+	if err != nil {
+		return objectReadiness{}, err
+	}
+	if d.Generation > d.Status.ObservedGeneration {
+		return notReady("waiting for the rollout to be observed"), nil
+	}
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+			return objectReadiness{failed: true, reason: c.Message}, nil
+		}
+	}
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	if d.Status.UpdatedReplicas < replicas {
+		return notReady("%d of %d replicas updated", d.Status.UpdatedReplicas, replicas), nil
+	}
+	if d.Status.Replicas > d.Status.UpdatedReplicas {
+		return notReady("%d old replicas are pending termination", d.Status.Replicas-d.Status.UpdatedReplicas), nil
+	}
+	if d.Status.AvailableReplicas < d.Status.UpdatedReplicas {
+		return notReady("%d of %d updated replicas available", d.Status.AvailableReplicas, d.Status.UpdatedReplicas), nil
+	}
+	return objectReadiness{ready: true}, nil
+}
+
+func getStatefulSetReadiness(k8sclient *kubernetes.Clientset, namespace string, name string) (objectReadiness, error) {
+	ss, err := k8sclient.AppsV1().StatefulSets(namespace).Get(context.Background(), name, v1.GetOptions{})
+	if err != nil {
+		return objectReadiness{}, err
+	}
+	if ss.Generation > ss.Status.ObservedGeneration {
+		return notReady("waiting for the rollout to be observed"), nil
+	}
+	replicas := int32(1)
+	if ss.Spec.Replicas != nil {
+		replicas = *ss.Spec.Replicas
+	}
+	if ss.Status.ReadyReplicas < replicas {
+		return notReady("%d of %d replicas ready", ss.Status.ReadyReplicas, replicas), nil
+	}
+	if ss.Spec.UpdateStrategy.Type != appsv1.RollingUpdateStatefulSetStrategyType {
+		// OnDelete pods are updated only when someone deletes them
+		return objectReadiness{ready: true}, nil
+	}
+	if ss.Spec.UpdateStrategy.RollingUpdate != nil && ss.Spec.UpdateStrategy.RollingUpdate.Partition != nil {
+		partitioned := replicas - *ss.Spec.UpdateStrategy.RollingUpdate.Partition
+		if ss.Status.UpdatedReplicas < partitioned {
+			return notReady("%d of %d partitioned replicas updated", ss.Status.UpdatedReplicas, partitioned), nil
+		}
+		return objectReadiness{ready: true}, nil
+	}
+	if ss.Status.UpdateRevision != ss.Status.CurrentRevision {
+		return notReady("%d of %d replicas updated", ss.Status.UpdatedReplicas, replicas), nil
+	}
+	return objectReadiness{ready: true}, nil
+}
+
+func getDaemonSetReadiness(k8sclient *kubernetes.Clientset, namespace string, name string) (objectReadiness, error) {
+	ds, err := k8sclient.AppsV1().DaemonSets(namespace).Get(context.Background(), name, v1.GetOptions{})
+	if err != nil {
+		return objectReadiness{}, err
+	}
+	if ds.Generation > ds.Status.ObservedGeneration {
+		return notReady("waiting for the rollout to be observed"), nil
+	}
+	if ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled {
+		return notReady("%d of %d pods updated", ds.Status.UpdatedNumberScheduled, ds.Status.DesiredNumberScheduled), nil
+	}
+	if ds.Status.NumberAvailable < ds.Status.DesiredNumberScheduled {
+		return notReady("%d of %d pods available", ds.Status.NumberAvailable, ds.Status.DesiredNumberScheduled), nil
+	}
+	return objectReadiness{ready: true}, nil
+}
+
+func getJobReadiness(k8sclient *kubernetes.Clientset, namespace string, name string) (objectReadiness, error) {
+	j, err := k8sclient.BatchV1().Jobs(namespace).Get(context.Background(), name, v1.GetOptions{})
+	if err != nil {
+		return objectReadiness{}, err
+	}
+	for _, c := range j.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return objectReadiness{ready: true}, nil
+		case batchv1.JobFailed:
+			return objectReadiness{failed: true, reason: fmt.Sprintf("failed: %s", c.Message)}, nil
+		}
+	}
+	completions := int32(1)
+	if j.Spec.Completions != nil {
+		completions = *j.Spec.Completions
+	}
+	return notReady("%d of %d completions succeeded", j.Status.Succeeded, completions), nil
+}
+
+func getPVCReadiness(k8sclient *kubernetes.Clientset, namespace string, name string) (objectReadiness, error) {
+	pvc, err := k8sclient.CoreV1().PersistentVolumeClaims(namespace).Get(context.Background(), name, v1.GetOptions{})
+	if err != nil {
+		return objectReadiness{}, err
+	}
+	switch pvc.Status.Phase {
+	case corev1.ClaimBound:
+		return objectReadiness{ready: true}, nil
+	case corev1.ClaimLost:
+		return objectReadiness{failed: true, reason: "the bound volume is lost"}, nil
+	}
+	return notReady("phase is %s", pvc.Status.Phase), nil
+}
+
+func getServiceReadiness(k8sclient *kubernetes.Clientset, namespace string, name string) (objectReadiness, error) {
+	svc, err := k8sclient.CoreV1().Services(namespace).Get(context.Background(), name, v1.GetOptions{})
+	if err != nil {
+		return objectReadiness{}, err
+	}
+	if svc.Spec.Type == corev1.ServiceTypeExternalName {
+		return objectReadiness{ready: true}, nil
+	}
+	if svc.Spec.Type == corev1.ServiceTypeLoadBalancer && len(svc.Status.LoadBalancer.Ingress) == 0 {
+		return notReady("waiting for a load balancer address"), nil
+	}
+	if len(svc.Spec.Selector) == 0 {
+		// the endpoints of a service without a selector are managed by someone else
+		return objectReadiness{ready: true}, nil
+	}
+	e, err := k8sclient.CoreV1().Endpoints(namespace).Get(context.Background(), name, v1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return notReady("no ready endpoints"), nil
+	}
+	if err != nil {
+		return objectReadiness{}, err
+	}
+	for _, subset := range e.Subsets {
+		if len(subset.Addresses) > 0 {
+			return objectReadiness{ready: true}, nil
+		}
+	}
+	return notReady("no ready endpoints"), nil
+}
+
+func getIngressReadiness(k8sclient *kubernetes.Clientset, namespace string, name string) (objectReadiness, error) {
+	i, err := k8sclient.NetworkingV1().Ingresses(namespace).Get(context.Background(), name, v1.GetOptions{})
+	if err != nil {
+		return objectReadiness{}, err
+	}
+	if len(i.Status.LoadBalancer.Ingress) == 0 {
+		return notReady("waiting for a load balancer address"), nil
+	}
+	return objectReadiness{ready: true}, nil
+}
+
+// diagnosisLogLines is the number of the last log lines collected from a failing container
+const diagnosisLogLines int64 = 10
+
+// diagnosisMaxPods limits the number of unhealthy pods diagnosed per object
+const diagnosisMaxPods = 3
+
+// diagnosisMaxEvents limits the number of warning events shown per object
+const diagnosisMaxEvents = 5
+
+// diagnoseObject explains why the object is unhealthy. A healthy pod returns nil
+func diagnoseObject(k8sclient *kubernetes.Clientset, kind string, namespace string, name string, reason string, events []corev1.Event) (*structs.ObjectDiagnosis, error) {
+	diagnosis := structs.ObjectDiagnosis{Kind: kind, Name: name, Reason: reason}
+	involved := map[string]bool{fmt.Sprintf("%s/%s", kind, name): true}
+
+	pods, err := getWorkloadPods(k8sclient, kind, namespace, name)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return nil, err
+	}
+	diagnosed := 0
+	for _, pod := range pods {
+		involved[fmt.Sprintf("%s/%s", structs.KIND_POD, pod.Name)] = true
+		if diagnosed >= diagnosisMaxPods {
+			continue
+		}
+		problems, failing := diagnosePod(pod)
+		if len(problems) == 0 {
+			continue
+		}
+		diagnosed++
+		for _, p := range problems {
+			diagnosis.Problems = append(diagnosis.Problems, fmt.Sprintf("Pod %s: %s", pod.Name, p))
+		}
+		var containers []string
+		for container := range failing {
+			containers = append(containers, container)
+		}
+		sort.Strings(containers)
+		for _, container := range containers {
+			lines, err := getContainerLogTail(k8sclient, namespace, pod.Name, container, failing[container])
+			if err != nil || len(lines) == 0 {
+				// the container may have not written anything yet
+				continue
+			}
+			diagnosis.Logs = append(diagnosis.Logs, structs.ContainerLogs{Pod: pod.Name, Container: container, Lines: lines})
+		}
+	}
+	if kind == structs.KIND_DEPLOYMENT {
+		// the replica sets get the events about the pods they were not able to create
+		replicaSets, err := getDeploymentReplicaSets(k8sclient, namespace, name)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return nil, err
+		}
+		for _, rs := range replicaSets {
+			involved[fmt.Sprintf("%s/%s", structs.KIND_REPLICA_SET, rs)] = true
+		}
+	}
+
+	var objectEvents []corev1.Event
+	for _, e := range events {
+		if involved[fmt.Sprintf("%s/%s", e.InvolvedObject.Kind, e.InvolvedObject.Name)] {
+			objectEvents = append(objectEvents, e)
+		}
+	}
+	sort.SliceStable(objectEvents, func(i, j int) bool {
+		return getEventTime(objectEvents[i]).Before(getEventTime(objectEvents[j]))
+	})
+	if len(objectEvents) > diagnosisMaxEvents {
+		objectEvents = objectEvents[len(objectEvents)-diagnosisMaxEvents:]
+	}
+	for _, e := range objectEvents {
+		event := fmt.Sprintf("%s %s: %s: %s", e.InvolvedObject.Kind, e.InvolvedObject.Name, e.Reason, strings.TrimSpace(e.Message))
+		if e.Count > 1 {
+			event = fmt.Sprintf("%s (x%d)", event, e.Count)
+		}
+		diagnosis.Events = append(diagnosis.Events, event)
+	}
+
+	if kind == structs.KIND_POD && len(diagnosis.Problems) == 0 && len(diagnosis.Events) == 0 {
+		return nil, nil
+	}
+	return &diagnosis, nil
+}
+
+// getWorkloadPods returns the pods managed by the workload or the pod itself
+func getWorkloadPods(k8sclient *kubernetes.Clientset, kind string, namespace string, name string) ([]corev1.Pod, error) {
+	var selector *v1.LabelSelector
+	switch kind {
+	case structs.KIND_POD:
+		p, err := k8sclient.CoreV1().Pods(namespace).Get(context.Background(), name, v1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return []corev1.Pod{*p}, nil
+	case structs.KIND_DEPLOYMENT:
+		d, err := k8sclient.AppsV1().Deployments(namespace).Get(context.Background(), name, v1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = d.Spec.Selector
+	case structs.KIND_STATEFUL_SET:
+		ss, err := k8sclient.AppsV1().StatefulSets(namespace).Get(context.Background(), name, v1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = ss.Spec.Selector
+	case structs.KIND_DAEMON_SET:
+		ds, err := k8sclient.AppsV1().DaemonSets(namespace).Get(context.Background(), name, v1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = ds.Spec.Selector
+	case structs.KIND_REPLICA_SET:
+		rs, err := k8sclient.AppsV1().ReplicaSets(namespace).Get(context.Background(), name, v1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = rs.Spec.Selector
+	case structs.KIND_JOB:
+		j, err := k8sclient.BatchV1().Jobs(namespace).Get(context.Background(), name, v1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		selector = j.Spec.Selector
+	}
+	if selector == nil {
+		return nil, nil
+	}
+
+	s, err := v1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := k8sclient.CoreV1().Pods(namespace).List(context.Background(), v1.ListOptions{LabelSelector: s.String()})
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+func getDeploymentReplicaSets(k8sclient *kubernetes.Clientset, namespace string, name string) ([]string, error) {
+	d, err := k8sclient.AppsV1().Deployments(namespace).Get(context.Background(), name, v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	s, err := v1.LabelSelectorAsSelector(d.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	replicaSets, err := k8sclient.AppsV1().ReplicaSets(namespace).List(context.Background(), v1.ListOptions{LabelSelector: s.String()})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, rs := range replicaSets.Items {
+		names = append(names, rs.Name)
+	}
+	return names, nil
+}
+
+// diagnosePod returns the pod problems and the failing containers.
+// A failing container is mapped to true when the logs of its previous run should be read.
+func diagnosePod(pod corev1.Pod) ([]string, map[string]bool) {
+	var problems []string
+	failing := make(map[string]bool)
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse {
+			problems = append(problems, fmt.Sprintf("unschedulable: %s", c.Message))
+		}
+	}
+
+	statuses := append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		if w := cs.State.Waiting; w != nil && w.Reason != "ContainerCreating" && w.Reason != "PodInitializing" {
+			problems = append(problems, fmt.Sprintf("container %s is waiting: %s", cs.Name, withMessage(w.Reason, w.Message)))
+			failing[cs.Name] = cs.LastTerminationState.Terminated != nil
+		}
+		if t := cs.State.Terminated; t != nil && t.ExitCode != 0 {
+			problems = append(problems, fmt.Sprintf("container %s terminated: %s (exit code %d)", cs.Name, withMessage(t.Reason, t.Message), t.ExitCode))
+			failing[cs.Name] = false
+		}
+		if t := cs.LastTerminationState.Terminated; t != nil && t.ExitCode != 0 && cs.State.Terminated == nil {
+			problems = append(problems, fmt.Sprintf("container %s last terminated: %s (exit code %d, %d restarts)", cs.Name, t.Reason, t.ExitCode, cs.RestartCount))
+			if _, ok := failing[cs.Name]; !ok {
+				failing[cs.Name] = cs.State.Running == nil
+			}
+		}
+	}
+	return problems, failing
+}
+
+func withMessage(reason string, message string) string {
+	message = strings.TrimSpace(message)
+	if len(message) == 0 {
+		return reason
+	}
+	return fmt.Sprintf("%s (%s)", reason, message)
+}
+
+func getContainerLogTail(k8sclient *kubernetes.Clientset, namespace string, pod string, container string, previous bool) ([]string, error) {
+	lines := diagnosisLogLines
+	raw, err := k8sclient.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{
+		Container: container,
+		TailLines: &lines,
+		Previous:  previous,
+	}).DoRaw(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	content := strings.TrimRight(string(raw), "\n")
+	if len(content) == 0 {
+		return nil, nil
+	}
+	return strings.Split(content, "\n"), nil
+}
+
+func getEventTime(e corev1.Event) time.Time {
+	if !e.LastTimestamp.IsZero() {
+		return e.LastTimestamp.Time
+	}
+	if !e.EventTime.IsZero() {
+		return e.EventTime.Time
+	}
+	return e.CreationTimestamp.Time
+}
+
+// getHookCompletion checks if the hook job or pod has completed
+func getHookCompletion(k8sclient *kubernetes.Clientset, kind string, namespace string, name string) objectReadiness {
+	if kind != structs.KIND_POD {
+		return getObjectReadiness(k8sclient, kind, namespace, name)
+	}
+	p, err := k8sclient.CoreV1().Pods(namespace).Get(context.Background(), name, v1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return notReady("not found")
+	}
+	if err != nil {
+		return notReady("%s", err)
+	}
+	switch p.Status.Phase {
+	case corev1.PodSucceeded:
+		return objectReadiness{ready: true}
+	case corev1.PodFailed:
+		return objectReadiness{failed: true, reason: fmt.Sprintf("failed: %s", withMessage(p.Status.Reason, p.Status.Message))}
+	}
+	return notReady("phase is %s", p.Status.Phase)
+}
@@ -0,0 +1,497 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestValidateApplications(t *testing.T) {
+	manifest := filepath.Join(t.TempDir(), "redis.yaml")
+	err := os.WriteFile(manifest, []byte("kind: ConfigMap\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		applications []structs.Application
+		want         []string
+	}{
+		{
+			name: "unique names",
+			applications: []structs.Application{
+				{Name: "redis", Chart: manifest},
+				{Name: "redis-config", Chart: manifest},
+			},
+		},
+		{
+			name: "duplicate names",
+			applications: []structs.Application{
+				{Name: "redis", Chart: manifest},
+				{Name: "redis", Chart: manifest},
+			},
+			want: []string{"Application 1: Name redis is already used by application 0"},
+		},
+		{
+			name:         "missing name",
+			applications: []structs.Application{{Chart: manifest}},
+			want:         []string{"Application 0: Name is missing"},
+		},
+		{
+			name:         "missing file",
+			applications: []structs.Application{{Name: "redis", Chart: manifest + ".missing"}},
+			want:         []string{"Application 0: Chart file can't be opened"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages := validateApplications(tt.applications)
+			if len(messages) != len(tt.want) {
+				t.Fatalf("validateApplications() = %q, want %q", messages, tt.want)
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(messages[i], want) {
+					t.Errorf("validateApplications()[%d] = %q, want %q", i, messages[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetObjectReadiness(t *testing.T) {
+	replicas := int32(3)
+	partition := int32(2)
+	objectMeta := func(generation int64) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: "web", Namespace: "default", Generation: generation}
+	}
+	tests := []struct {
+		name    string
+		kind    string
+		objects map[string]interface{}
+		want    objectReadiness
+	}{
+		{
+			name: "deployment rollout not observed",
+			kind: structs.KIND_DEPLOYMENT,
+			objects: map[string]interface{}{"deployments/web": &appsv1.Deployment{
+				ObjectMeta: objectMeta(2),
+				Status:     appsv1.DeploymentStatus{ObservedGeneration: 1},
+			}},
+			want: objectReadiness{reason: "waiting for the rollout to be observed"},
+		},
+		{
+			name: "deployment progress deadline exceeded",
+			kind: structs.KIND_DEPLOYMENT,
+			objects: map[string]interface{}{"deployments/web": &appsv1.Deployment{
+				ObjectMeta: objectMeta(1),
+				Status: appsv1.DeploymentStatus{ObservedGeneration: 1, Conditions: []appsv1.DeploymentCondition{{
+					Type:    appsv1.DeploymentProgressing,
+					Reason:  "ProgressDeadlineExceeded",
+					Message: `ReplicaSet "web-5d8f" has timed out progressing.`,
+				}}},
+			}},
+			want: objectReadiness{failed: true, reason: `ReplicaSet "web-5d8f" has timed out progressing.`},
+		},
+		{
+			name: "deployment replicas being updated",
+			kind: structs.KIND_DEPLOYMENT,
+			objects: map[string]interface{}{"deployments/web": &appsv1.Deployment{
+				ObjectMeta: objectMeta(1),
+				Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+				Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 1},
+			}},
+			want: objectReadiness{reason: "1 of 3 replicas updated"},
+		},
+		{
+			name: "deployment old replicas terminating",
+			kind: structs.KIND_DEPLOYMENT,
+			objects: map[string]interface{}{"deployments/web": &appsv1.Deployment{
+				ObjectMeta: objectMeta(1),
+				Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+				Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 4, UpdatedReplicas: 3},
+			}},
+			want: objectReadiness{reason: "1 old replicas are pending termination"},
+		},
+		{
+			name: "deployment replicas not available",
+			kind: structs.KIND_DEPLOYMENT,
+			objects: map[string]interface{}{"deployments/web": &appsv1.Deployment{
+				ObjectMeta: objectMeta(1),
+				Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+				Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 2},
+			}},
+			want: objectReadiness{reason: "2 of 3 updated replicas available"},
+		},
+		{
+			name: "deployment ready",
+			kind: structs.KIND_DEPLOYMENT,
+			objects: map[string]interface{}{"deployments/web": &appsv1.Deployment{
+				ObjectMeta: objectMeta(1),
+				Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+			}},
+			want: objectReadiness{ready: true},
+		},
+		{
+			name: "stateful set replicas not ready",
+			kind: structs.KIND_STATEFUL_SET,
+			objects: map[string]interface{}{"statefulsets/web": &appsv1.StatefulSet{
+				ObjectMeta: objectMeta(1),
+				Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+				Status:     appsv1.StatefulSetStatus{ObservedGeneration: 1, ReadyReplicas: 1},
+			}},
+			want: objectReadiness{reason: "1 of 3 replicas ready"},
+		},
+		{
+			name: "stateful set with on delete updates",
+			kind: structs.KIND_STATEFUL_SET,
+			objects: map[string]interface{}{"statefulsets/web": &appsv1.StatefulSet{
+				ObjectMeta: objectMeta(1),
+				Spec:       appsv1.StatefulSetSpec{UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType}},
+				Status:     appsv1.StatefulSetStatus{ObservedGeneration: 1, ReadyReplicas: 1, CurrentRevision: "web-1", UpdateRevision: "web-2"},
+			}},
+			want: objectReadiness{ready: true},
+		},
+		{
+			name: "stateful set partition being updated",
+			kind: structs.KIND_STATEFUL_SET,
+			objects: map[string]interface{}{"statefulsets/web": &appsv1.StatefulSet{
+				ObjectMeta: objectMeta(1),
+				Spec: appsv1.StatefulSetSpec{Replicas: &replicas, UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+					Type:          appsv1.RollingUpdateStatefulSetStrategyType,
+					RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{Partition: &partition},
+				}},
+				Status: appsv1.StatefulSetStatus{ObservedGeneration: 1, ReadyReplicas: 3},
+			}},
+			want: objectReadiness{reason: "0 of 1 partitioned replicas updated"},
+		},
+		{
+			name: "stateful set rolling update in progress",
+			kind: structs.KIND_STATEFUL_SET,
+			objects: map[string]interface{}{"statefulsets/web": &appsv1.StatefulSet{
+				ObjectMeta: objectMeta(1),
+				Spec:       appsv1.StatefulSetSpec{Replicas: &replicas, UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: appsv1.RollingUpdateStatefulSetStrategyType}},
+				Status:     appsv1.StatefulSetStatus{ObservedGeneration: 1, ReadyReplicas: 3, UpdatedReplicas: 2, CurrentRevision: "web-1", UpdateRevision: "web-2"},
+			}},
+			want: objectReadiness{reason: "2 of 3 replicas updated"},
+		},
+		{
+			name: "stateful set ready",
+			kind: structs.KIND_STATEFUL_SET,
+			objects: map[string]interface{}{"statefulsets/web": &appsv1.StatefulSet{
+				ObjectMeta: objectMeta(1),
+				Spec:       appsv1.StatefulSetSpec{Replicas: &replicas, UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: appsv1.RollingUpdateStatefulSetStrategyType}},
+				Status:     appsv1.StatefulSetStatus{ObservedGeneration: 1, ReadyReplicas: 3, CurrentRevision: "web-2", UpdateRevision: "web-2"},
+			}},
+			want: objectReadiness{ready: true},
+		},
+		{
+			name: "daemon set pods being updated",
+			kind: structs.KIND_DAEMON_SET,
+			objects: map[string]interface{}{"daemonsets/web": &appsv1.DaemonSet{
+				ObjectMeta: objectMeta(1),
+				Status:     appsv1.DaemonSetStatus{ObservedGeneration: 1, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 2},
+			}},
+			want: objectReadiness{reason: "2 of 3 pods updated"},
+		},
+		{
+			name: "daemon set pods not available",
+			kind: structs.KIND_DAEMON_SET,
+			objects: map[string]interface{}{"daemonsets/web": &appsv1.DaemonSet{
+				ObjectMeta: objectMeta(1),
+				Status:     appsv1.DaemonSetStatus{ObservedGeneration: 1, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 1},
+			}},
+			want: objectReadiness{reason: "1 of 3 pods available"},
+		},
+		{
+			name: "daemon set ready",
+			kind: structs.KIND_DAEMON_SET,
+			objects: map[string]interface{}{"daemonsets/web": &appsv1.DaemonSet{
+				ObjectMeta: objectMeta(1),
+				Status:     appsv1.DaemonSetStatus{ObservedGeneration: 1, DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 3},
+			}},
+			want: objectReadiness{ready: true},
+		},
+		{
+			name: "job running",
+			kind: structs.KIND_JOB,
+			objects: map[string]interface{}{"jobs/web": &batchv1.Job{
+				ObjectMeta: objectMeta(1),
+				Spec:       batchv1.JobSpec{Completions: &replicas},
+				Status:     batchv1.JobStatus{Succeeded: 1},
+			}},
+			want: objectReadiness{reason: "1 of 3 completions succeeded"},
+		},
+		{
+			name: "job complete",
+			kind: structs.KIND_JOB,
+			objects: map[string]interface{}{"jobs/web": &batchv1.Job{
+				ObjectMeta: objectMeta(1),
+				Status:     batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}},
+			}},
+			want: objectReadiness{ready: true},
+		},
+		{
+			name: "job failed",
+			kind: structs.KIND_JOB,
+			objects: map[string]interface{}{"jobs/web": &batchv1.Job{
+				ObjectMeta: objectMeta(1),
+				Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+					{Type: batchv1.JobComplete, Status: corev1.ConditionFalse},
+					{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "Job has reached the specified backoff limit"},
+				}},
+			}},
+			want: objectReadiness{failed: true, reason: "failed: Job has reached the specified backoff limit"},
+		},
+		{
+			name: "persistent volume claim pending",
+			kind: structs.KIND_PVC,
+			objects: map[string]interface{}{"persistentvolumeclaims/web": &corev1.PersistentVolumeClaim{
+				ObjectMeta: objectMeta(0),
+				Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending},
+			}},
+			want: objectReadiness{reason: "phase is Pending"},
+		},
+		{
+			name: "persistent volume claim lost",
+			kind: structs.KIND_PVC,
+			objects: map[string]interface{}{"persistentvolumeclaims/web": &corev1.PersistentVolumeClaim{
+				ObjectMeta: objectMeta(0),
+				Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimLost},
+			}},
+			want: objectReadiness{failed: true, reason: "the bound volume is lost"},
+		},
+		{
+			name: "persistent volume claim bound",
+			kind: structs.KIND_PVC,
+			objects: map[string]interface{}{"persistentvolumeclaims/web": &corev1.PersistentVolumeClaim{
+				ObjectMeta: objectMeta(0),
+				Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+			}},
+			want: objectReadiness{ready: true},
+		},
+		{
+			name: "external name service",
+			kind: structs.KIND_SERVICE,
+			objects: map[string]interface{}{"services/web": &corev1.Service{
+				ObjectMeta: objectMeta(0),
+				Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: "web.example.com"},
+			}},
+			want: objectReadiness{ready: true},
+		},
+		{
+			name: "load balancer service without an address",
+			kind: structs.KIND_SERVICE,
+			objects: map[string]interface{}{"services/web": &corev1.Service{
+				ObjectMeta: objectMeta(0),
+				Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+			}},
+			want: objectReadiness{reason: "waiting for a load balancer address"},
+		},
+		{
+			name: "service without a selector",
+			kind: structs.KIND_SERVICE,
+			objects: map[string]interface{}{"services/web": &corev1.Service{
+				ObjectMeta: objectMeta(0),
+				Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+			}},
+			want: objectReadiness{ready: true},
+		},
+		{
+			name: "service without endpoints",
+			kind: structs.KIND_SERVICE,
+			objects: map[string]interface{}{"services/web": &corev1.Service{
+				ObjectMeta: objectMeta(0),
+				Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP, Selector: map[string]string{"app": "web"}},
+			}},
+			want: objectReadiness{reason: "no ready endpoints"},
+		},
+		{
+			name: "service without ready endpoints",
+			kind: structs.KIND_SERVICE,
+			objects: map[string]interface{}{
+				"services/web": &corev1.Service{
+					ObjectMeta: objectMeta(0),
+					Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP, Selector: map[string]string{"app": "web"}},
+				},
+				"endpoints/web": &corev1.Endpoints{
+					ObjectMeta: objectMeta(0),
+					Subsets:    []corev1.EndpointSubset{{NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}},
+				},
+			},
+			want: objectReadiness{reason: "no ready endpoints"},
+		},
+		{
+			name: "service with ready endpoints",
+			kind: structs.KIND_SERVICE,
+			objects: map[string]interface{}{
+				"services/web": &corev1.Service{
+					ObjectMeta: objectMeta(0),
+					Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP, Selector: map[string]string{"app": "web"}},
+				},
+				"endpoints/web": &corev1.Endpoints{
+					ObjectMeta: objectMeta(0),
+					Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}},
+				},
+			},
+			want: objectReadiness{ready: true},
+		},
+		{
+			name: "ingress without an address",
+			kind: structs.KIND_INGRESS,
+			objects: map[string]interface{}{"ingresses/web": &networkingv1.Ingress{
+				ObjectMeta: objectMeta(1),
+			}},
+			want: objectReadiness{reason: "waiting for a load balancer address"},
+		},
+		{
+			name: "ingress with an address",
+			kind: structs.KIND_INGRESS,
+			objects: map[string]interface{}{"ingresses/web": &networkingv1.Ingress{
+				ObjectMeta: objectMeta(1),
+				Status: networkingv1.IngressStatus{LoadBalancer: networkingv1.IngressLoadBalancerStatus{
+					Ingress: []networkingv1.IngressLoadBalancerIngress{{IP: "192.0.2.10"}},
+				}},
+			}},
+			want: objectReadiness{ready: true},
+		},
+		{
+			name: "missing object",
+			kind: structs.KIND_DEPLOYMENT,
+			want: objectReadiness{reason: "not found"},
+		},
+		{
+			name: "kind without readiness",
+			kind: "ConfigMap",
+			want: objectReadiness{ready: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, key, _ := strings.Cut(r.URL.Path, "/namespaces/default/")
+				obj, ok := tt.objects[key]
+				if !ok {
+					writeTestResponse(w, http.StatusNotFound, newTestStatus(http.StatusNotFound, metav1.StatusReasonNotFound, fmt.Sprintf("%s not found", key)))
+					return
+				}
+				writeTestResponse(w, http.StatusOK, obj)
+			}))
+			defer server.Close()
+			client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := getObjectReadiness(client, tt.kind, "default", "web"); got != tt.want {
+				t.Errorf("getObjectReadiness() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiagnosePod(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       corev1.PodStatus
+		wantProblems []string
+		wantFailing  map[string]bool
+	}{
+		{
+			name: "healthy pod",
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "web", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			}},
+			wantFailing: map[string]bool{},
+		},
+		{
+			name: "starting containers are not a problem",
+			status: corev1.PodStatus{
+				InitContainerStatuses: []corev1.ContainerStatus{
+					{Name: "migrate", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "PodInitializing"}}},
+				},
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "web", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}},
+				},
+			},
+			wantFailing: map[string]bool{},
+		},
+		{
+			name: "unschedulable pod",
+			status: corev1.PodStatus{Conditions: []corev1.PodCondition{{
+				Type:    corev1.PodScheduled,
+				Status:  corev1.ConditionFalse,
+				Message: "0/3 nodes are available: 3 Insufficient memory.",
+			}}},
+			wantProblems: []string{"unschedulable: 0/3 nodes are available: 3 Insufficient memory."},
+			wantFailing:  map[string]bool{},
+		},
+		{
+			name: "image pull failure",
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "web",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull", Message: " manifest unknown\n"}},
+			}}},
+			wantProblems: []string{"container web is waiting: ErrImagePull (manifest unknown)"},
+			wantFailing:  map[string]bool{"web": false},
+		},
+		{
+			name: "crash loop reads the previous logs",
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				Name:                 "web",
+				State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 1}},
+				RestartCount:         4,
+			}}},
+			wantProblems: []string{
+				"container web is waiting: CrashLoopBackOff",
+				"container web last terminated: Error (exit code 1, 4 restarts)",
+			},
+			wantFailing: map[string]bool{"web": true},
+		},
+		{
+			name: "failed init container",
+			status: corev1.PodStatus{InitContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "migrate",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 2}},
+			}}},
+			wantProblems: []string{"container migrate terminated: Error (exit code 2)"},
+			wantFailing:  map[string]bool{"migrate": false},
+		},
+		{
+			name: "restarted container running again",
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				Name:                 "web",
+				State:                corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+				RestartCount:         1,
+			}}},
+			wantProblems: []string{"container web last terminated: OOMKilled (exit code 137, 1 restarts)"},
+			wantFailing:  map[string]bool{"web": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, failing := diagnosePod(corev1.Pod{Status: tt.status})
+			if !reflect.DeepEqual(problems, tt.wantProblems) {
+				t.Errorf("diagnosePod() problems = %q, want %q", problems, tt.wantProblems)
+			}
+			if !reflect.DeepEqual(failing, tt.wantFailing) {
+				t.Errorf("diagnosePod() failing = %v, want %v", failing, tt.wantFailing)
+			}
+		})
+	}
+}
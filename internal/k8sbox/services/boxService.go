@@ -2,24 +2,50 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/pmezard/go-difflib/difflib"
 	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
 	"github.com/twelvee/k8sbox/pkg/k8sbox/utils"
 	"helm.sh/helm/v3/pkg/chart/loader"
 	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/cli-runtime/pkg/resource"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/strings/slices"
+	"sigs.k8s.io/yaml"
 )
 
+const crdEstablishTimeout = time.Minute
+
+// hookTimeout is the time k8sbox waits for a single hook to complete (the same default helm uses)
+const hookTimeout = 5 * time.Minute
+
+// readinessPollInterval is the time between two readiness checks of the box objects
+const readinessPollInterval = 2 * time.Second
+
 // NewBoxService creates a new BoxService
 func NewBoxService() structs.BoxService {
 	return structs.BoxService{
@@ -29,10 +55,25 @@ func NewBoxService() structs.BoxService {
 		UninstallBox:            uninstallBox,
 		DescribeBoxApplications: describeBoxApplications,
 		ExpandBoxVariables:      expandBoxVariables,
+		DiagnoseBox:             diagnoseBox,
 	}
 }
 
 func fillEmptyFields(environment structs.Environment, box *structs.Box) error {
+	variables := make(map[string]string)
+	if len(strings.TrimSpace(environment.Variables)) != 0 {
+		var err error
+		variables, err = godotenv.Read(environment.Variables)
+		if err != nil {
+			return err
+		}
+	}
+	return fillBoxFields(environment, box, os.Getenv, variables)
+}
+
+// fillBoxFields fills the empty fields of the box and renders it. The variables of the chart values are looked up with getenv,
+// the plain manifests are expanded with the defined variables only.
+func fillBoxFields(environment structs.Environment, box *structs.Box, getenv func(string) string, variables map[string]string) error {
 	if len(strings.TrimSpace(box.Namespace)) == 0 {
 		if len(strings.TrimSpace(environment.Namespace)) == 0 {
 			box.Namespace = strings.ToLower(strings.Join([]string{"k8srun", utils.GetShortNamespace(8)}, "-"))
@@ -44,17 +85,28 @@ func fillEmptyFields(environment structs.Environment, box *structs.Box) error {
 		box.Name = strings.ToLower(strings.Join([]string{"k8srun", utils.GetShortNamespace(8)}, "-"))
 	}
 
-	if box.Type == structs.Helm() {
-		err := createHelmRenders(environment, box)
+	switch box.Type {
+	case structs.Helm():
+		err := createHelmRenders(environment, box, getenv)
+		if err != nil {
+			return err
+		}
+	case structs.Plain():
+		err := createPlainRenders(box, variables)
 		if err != nil {
 			return err
 		}
 	}
 
+	render, err := utils.AddOwnershipLabels(box.HelmRender, environment.ID, environment.Namespace, box.Name)
+	if err != nil {
+		return err
+	}
+	box.HelmRender = render
 	return nil
 }
 
-func createHelmRenders(environment structs.Environment, box *structs.Box) error {
+func createHelmRenders(environment structs.Environment, box *structs.Box, getenv func(string) string) error {
 	chart, err := loader.Load(filepath.Dir(box.Chart))
 	if err != nil {
 		return err
@@ -67,10 +119,18 @@ func createHelmRenders(environment structs.Environment, box *structs.Box) error
 	}
 
 	e := engine.New(restConfig)
-	var replacedValues map[string]interface{}
+	boxValues, err := mergeValuesFiles(box.GetValuesFiles())
+	if err != nil {
+		return err
+	}
 	if len(strings.TrimSpace(environment.Variables)) != 0 {
-		replacedValues = processEnvValues(chart.Values, environment.Variables)
+		err = godotenv.Load(environment.Variables)
+		if err != nil {
+			return err
+		}
 	}
+	// the chart defaults are expanded as well, the values files are merged over them first
+	replacedValues := expandValues(mergeValues(chart.Values, boxValues), getenv)
 	vals, err := chartutil.ToRenderValues(chart, replacedValues, releaseOptions, nil)
 	if err != nil {
 		return err
@@ -79,10 +139,69 @@ func createHelmRenders(environment structs.Environment, box *structs.Box) error
 	if err != nil {
 		return err
 	}
-	box.HelmRender = render
+	box.HelmRender = utils.CleanHelmRender(render)
+
+	crds := make(map[string]string)
+	for _, crd := range chart.CRDObjects() {
+		crds[crd.Filename] = string(crd.File.Data)
+	}
+	box.CRDRender = utils.CleanHelmRender(crds)
+	return nil
+}
+
+// mergeValuesFiles deep-merges values files in order, so the latter files override the former ones
+func mergeValuesFiles(files []string) (map[string]interface{}, error) {
+	merged := make(map[string]interface{})
+	for _, file := range files {
+		vals, err := chartutil.ReadValuesFile(file)
+		if err != nil {
+			return nil, err
+		}
+		merged = mergeValues(merged, vals.AsMap())
+	}
+	return merged, nil
+}
+
+func mergeValues(base map[string]interface{}, override map[string]interface{}) map[string]interface{} {
+	for k, v := range override {
+		if overrideTable, ok := v.(map[string]interface{}); ok {
+			if baseTable, ok := base[k].(map[string]interface{}); ok {
+				base[k] = mergeValues(baseTable, overrideTable)
+				continue
+			}
+		}
+		base[k] = v
+	}
+	return base
+}
+
+func createPlainRenders(box *structs.Box, variables map[string]string) error {
+	render := make(map[string]string)
+	for _, application := range box.Applications {
+		content, err := os.ReadFile(application.Chart)
+		if err != nil {
+			return err
+		}
+		render[application.Name] = expandManifest(string(content), variables)
+	}
+	box.HelmRender = utils.CleanHelmRender(render)
 	return nil
 }
 
+var variableReference = regexp.MustCompile(`\$\{[A-Za-z_][A-Za-z0-9_]*\}|\$[A-Za-z_][A-Za-z0-9_]*`)
+
+// expandManifest expands the references of the defined variables in the manifest, any other $ is kept as it is.
+// Manifests carry shell scripts and the $(VAR) references of kubernetes that have to reach the cluster untouched.
+func expandManifest(manifest string, variables map[string]string) string {
+	return variableReference.ReplaceAllStringFunc(manifest, func(reference string) string {
+		value, ok := variables[strings.Trim(reference, "${}")]
+		if !ok {
+			return reference
+		}
+		return value
+	})
+}
+
 func processEnvValues(values map[string]interface{}, dotenvPath string) map[string]interface{} {
 	if len(dotenvPath) > 0 {
 		err := godotenv.Load(dotenvPath)
@@ -90,40 +209,91 @@ func processEnvValues(values map[string]interface{}, dotenvPath string) map[stri
 			panic(err)
 		}
 	}
+	return expandValues(values, os.Getenv)
+}
+
+// expandValues expands the variables in every string of the values, the variables are looked up with getenv
+func expandValues(values map[string]interface{}, getenv func(string) string) map[string]interface{} {
 	for k, v := range values {
-		if len(os.ExpandEnv(fmt.Sprintf("%v", v))) == 0 {
-			continue
-		}
-		values[k] = os.ExpandEnv(fmt.Sprintf("%v", v))
+		values[k] = expandEnvValue(v, getenv)
 	}
 	return values
 }
 
+func expandEnvValue(value interface{}, getenv func(string) string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, nested := range v {
+			v[k] = expandEnvValue(nested, getenv)
+		}
+		return v
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = expandEnvValue(nested, getenv)
+		}
+		return v
+	case string:
+		expanded := os.Expand(v, getenv)
+		if len(expanded) == 0 {
+			return v
+		}
+		return expanded
+	}
+	return value
+}
+
 func validateBoxes(boxes []structs.Box) error {
 	var messages []string
+	// names are the keys of the dependencies, the apply and the prune, the boxes without a name get a generated one
+	names := make(map[string]int)
 	for index, box := range boxes {
 		if len(strings.TrimSpace(box.Type)) == 0 {
 			messages = append(messages, fmt.Sprintf("-> Box %d: Type is missing", index))
+		} else if box.Type != structs.Helm() && box.Type != structs.Plain() {
+			messages = append(messages, fmt.Sprintf("-> Box %d: Unknown type %s (available types: %s, %s)", index, box.Type, structs.Helm(), structs.Plain()))
+		}
+
+		if errs := validation.IsValidLabelValue(box.Name); len(errs) > 0 {
+			messages = append(messages, fmt.Sprintf("-> Box %d: Name can't be used as a label value (%s)", index, strings.Join(errs, "; ")))
+		}
+		if len(box.Name) != 0 {
+			if other, ok := names[box.Name]; ok {
+				messages = append(messages, fmt.Sprintf("-> Box %d: Name %s is already used by box %d", index, box.Name, other))
+			} else {
+				names[box.Name] = index
+			}
 		}
 
 		if len(box.Applications) == 0 && box.Type != structs.Helm() {
 			messages = append(messages, fmt.Sprintf("-> Box %d: Applications are missing", index))
 		}
 
-		if len(strings.TrimSpace(box.Chart)) == 0 {
-			messages = append(messages, fmt.Sprintf("-> Box %d: Chart is missing", index))
-		}
-		_, err := os.Stat(box.Chart)
-		if err != nil {
-			messages = append(messages, fmt.Sprintf("-> Box %d: Chart file can't be opened (%s)", index, box.Chart))
-		}
+		if box.Type == structs.Helm() {
+			if len(strings.TrimSpace(box.Chart)) == 0 {
+				messages = append(messages, fmt.Sprintf("-> Box %d: Chart is missing", index))
+			}
+			_, err := os.Stat(box.Chart)
+			if err != nil {
+				messages = append(messages, fmt.Sprintf("-> Box %d: Chart file can't be opened (%s)", index, box.Chart))
+			}
 
-		if len(strings.TrimSpace(box.Values)) == 0 {
-			messages = append(messages, fmt.Sprintf("-> Box %d: Values are missing", index))
+			if len(box.GetValuesFiles()) == 0 {
+				messages = append(messages, fmt.Sprintf("-> Box %d: Values are missing", index))
+			}
+			for _, valuesFile := range box.GetValuesFiles() {
+				_, err = os.Stat(valuesFile)
+				if err != nil {
+					messages = append(messages, fmt.Sprintf("-> Box %d: Values file can't be opened (%s)", index, valuesFile))
+				}
+			}
 		}
-		_, err = os.Stat(box.Values)
-		if err != nil {
-			messages = append(messages, fmt.Sprintf("-> Box %d: Values file can't be opened (%s)", index, box.Values))
+
+		for _, dependency := range box.DependsOn {
+			if dependency == box.Name {
+				messages = append(messages, fmt.Sprintf("-> Box %d: Box can't depend on itself", index))
+			} else if !hasBox(boxes, dependency) {
+				messages = append(messages, fmt.Sprintf("-> Box %d: Depends on an unknown box %s", index, dependency))
+			}
 		}
 
 		if box.Type != structs.Helm() {
@@ -135,147 +305,1330 @@ func validateBoxes(boxes []structs.Box) error {
 			}
 		}
 	}
+	if cycle := utils.FindBoxDependencyCycle(boxes); cycle != nil {
+		messages = append(messages, fmt.Sprintf("-> Box dependencies contain a cycle: %s", strings.Join(cycle, " -> ")))
+	}
 	if len(messages) > 0 {
 		return errors.New(strings.Join(messages, "\n\r"))
 	}
 	return nil
 }
 
-func installBox(box *structs.Box, environment structs.Environment) ([]*runtime.Object, error) {
+func hasBox(boxes []structs.Box, name string) bool {
+	for _, box := range boxes {
+		if box.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// installBox creates the box objects, the hooks of the box have to complete by the deadline
+func installBox(box *structs.Box, environment structs.Environment, tracker *objectTracker, deadline time.Time) ([]*runtime.Object, error) {
 	var objects []*runtime.Object
 
-	r := utils.ConvertHelmRenderToYaml(box.HelmRender)
-	for _, rend := range r {
-		obj, err := utils.CreateRuntimeObject(rend)
+	// CRDs go first, so the custom resources of the box can be mapped
+	err := installBoxCRDs(*box)
+	if err != nil {
+		return nil, err
+	}
+	err = runBoxHooks(*box, installHooks.pre, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rend := range utils.GetInstallManifests(box.HelmRender) {
+		o, err := newBoxObject(rend, *box)
 		if err != nil {
 			return nil, err
 		}
 
-		mapping, err := utils.CreateRestMapper(k8sclient, obj)
-		if err != nil {
-			return nil, err
+		var clusterObject *structs.ClusterObject
+		if o.isClusterScoped() {
+			co, err := newClusterObject(o.mapping, o.obj)
+			if err != nil {
+				return nil, err
+			}
+			owner, err := findClusterObjectOwner(environment, co)
+			if err != nil {
+				return nil, err
+			}
+			if owner != nil {
+				return nil, fmt.Errorf("Cluster-scoped %s %s already belongs to the environment %s (namespace %s)", co.Kind, co.Name, owner.ID, owner.Namespace)
+			}
+			clusterObject = &co
 		}
 
-		restClient, err := utils.NewRestClient(*restConfig, mapping.GroupVersionKind.GroupVersion())
+		err = setRevisionAnnotation(o.obj, environment.Revision)
 		if err != nil {
 			return nil, err
 		}
-
-		// Use the REST helper to create the object in the box namespace.
-		restHelper := resource.NewHelper(restClient, mapping)
-		rtobj, err := restHelper.Create(box.Namespace, false, obj)
+		rtobj, err := o.restHelper.Create(o.namespace, false, o.obj)
 		if err != nil {
 			return nil, err
 		}
+		tracker.track(box.Name, o)
+		if clusterObject != nil {
+			box.ClusterObjects = append(box.ClusterObjects, *clusterObject)
+		}
 		objects = append(objects, &rtobj)
 	}
 
+	err = runBoxPostHooks(*box, installHooks.post, deadline)
+	if err != nil {
+		return nil, err
+	}
 	return objects, nil
 }
 
-func uninstallBox(environment structs.Environment, box structs.Box) ([]*runtime.Object, error) {
-	var objects []*runtime.Object
+// recreateMissingObjects creates the objects of the saved box that were deleted from the cluster.
+// Cluster-scoped objects are created again only if the box owns them.
+func recreateMissingObjects(box structs.Box, environment structs.Environment) ([]string, error) {
+	var recreated []string
+	err := createNamespaceIfNotExists(box.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	err = installBoxCRDs(box)
+	if err != nil {
+		return nil, err
+	}
 
-	r := utils.ConvertHelmRenderToYaml(box.HelmRender)
-	for _, rend := range r {
-		obj, err := utils.CreateRuntimeObject(rend)
+	for _, rend := range utils.GetInstallManifests(box.HelmRender) {
+		o, err := newBoxObject(rend, box)
 		if err != nil {
 			return nil, err
 		}
+		_, err = o.restHelper.Get(o.namespace, o.name)
+		if err == nil {
+			continue
+		}
+		if !k8serrors.IsNotFound(err) {
+			return nil, err
+		}
+		if o.isClusterScoped() {
+			co, err := newClusterObject(o.mapping, o.obj)
+			if err != nil {
+				return nil, err
+			}
+			if !hasClusterObject(box, co) {
+				continue
+			}
+		}
 
-		mapping, err := utils.CreateRestMapper(k8sclient, obj)
+		err = setRevisionAnnotation(o.obj, environment.Revision)
 		if err != nil {
 			return nil, err
 		}
-
-		restClient, err := utils.NewRestClient(*restConfig, mapping.GroupVersionKind.GroupVersion())
+		_, err = o.restHelper.Create(o.namespace, false, o.obj)
+		if k8serrors.IsAlreadyExists(err) {
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
+		recreated = append(recreated, fmt.Sprintf("%s/%s", o.mapping.GroupVersionKind.Kind, o.name))
+	}
+	return recreated, nil
+}
 
-		// Use the REST helper to create the object in the box namespace.
-		restHelper := resource.NewHelper(restClient, mapping)
+func hasClusterObject(box structs.Box, clusterObject structs.ClusterObject) bool {
+	for _, co := range box.ClusterObjects {
+		if co == clusterObject {
+			return true
+		}
+	}
+	return false
+}
 
-		name, err := meta.NewAccessor().Name(obj)
-		if err != nil {
-			return nil, err
+// objectTracker records the objects created during a deploy, so an atomic deploy can delete them on failure.
+// A nil tracker records nothing.
+type objectTracker struct {
+	mutex   sync.Mutex
+	objects []trackedObject
+}
+
+type trackedObject struct {
+	box    string
+	object *boxObject
+}
+
+func (t *objectTracker) track(box string, o *boxObject) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.objects = append(t.objects, trackedObject{box: box, object: o})
+}
+
+// rollback deletes every tracked object in the reversed creation order
+func (t *objectTracker) rollback() error {
+	return t.rollbackBoxes(nil)
+}
+
+// rollbackBoxes deletes the tracked objects of the boxes in the reversed creation order. Every box is rolled back when boxes is nil
+func (t *objectTracker) rollbackBoxes(boxes []string) error {
+	if t == nil {
+		return nil
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var messages []string
+	var kept []trackedObject
+	for i := len(t.objects) - 1; i >= 0; i-- {
+		tracked := t.objects[i]
+		if boxes != nil && !slices.Contains(boxes, tracked.box) {
+			kept = append([]trackedObject{tracked}, kept...)
+			continue
 		}
+		o := tracked.object
+		_, err := o.restHelper.Delete(o.namespace, o.name)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			messages = append(messages, fmt.Sprintf("%s %s: %s", o.mapping.GroupVersionKind.Kind, o.name, err))
+		}
+	}
+	t.objects = kept
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "\n\r"))
+	}
+	return nil
+}
+
+// applyHooks are the hook events fired around applying a box
+type applyHooks struct {
+	pre  release.HookEvent
+	post release.HookEvent
+}
+
+var (
+	installHooks  = applyHooks{pre: release.HookPreInstall, post: release.HookPostInstall}
+	upgradeHooks  = applyHooks{pre: release.HookPreUpgrade, post: release.HookPostUpgrade}
+	rollbackHooks = applyHooks{pre: release.HookPreRollback, post: release.HookPostRollback}
+)
 
-		rtobj, err := restHelper.Delete(box.Namespace, name)
+// runBoxHooks runs the box hooks fired on the event one by one, respecting their weights and delete policies.
+// A hook has hookTimeout to complete, or less if the deadline comes first. A zero deadline sets no limit of its own.
+func runBoxHooks(box structs.Box, event release.HookEvent, deadline time.Time) error {
+	hooks, err := utils.GetBoxHooks(box.HelmRender, event)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		err := runBoxHook(box, hook, getHookTimeout(deadline))
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("The %s hook %s %s of box %s failed: %s", event, hook.Kind, hook.Name, box.Name, err)
 		}
-		objects = append(objects, &rtobj)
 	}
+	return nil
+}
 
-	err := deleteSavedBox(environment, box)
+// runBoxPostHooks runs the post hooks once the box is ready, so they can rely on the box applications
+func runBoxPostHooks(box structs.Box, event release.HookEvent, deadline time.Time) error {
+	hooks, err := utils.GetBoxHooks(box.HelmRender, event)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	err = waitForBoxes([]structs.Box{box}, getHookTimeout(deadline), nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return objects, nil
+	return runBoxHooks(box, event, deadline)
 }
 
-func describeBoxApplications(environment structs.Environment, box structs.Box) error {
-	r := utils.ConvertHelmRenderToYaml(box.HelmRender)
-	for _, rend := range r {
-		obj, err := utils.CreateRuntimeObject(rend)
+// getHookTimeout returns the time left for a hook, at most hookTimeout. The hook is checked at least once past the deadline.
+func getHookTimeout(deadline time.Time) time.Duration {
+	if deadline.IsZero() {
+		return hookTimeout
+	}
+	timeout := time.Until(deadline)
+	if timeout > hookTimeout {
+		return hookTimeout
+	}
+	if timeout < readinessPollInterval {
+		return readinessPollInterval
+	}
+	return timeout
+}
+
+func runBoxHook(box structs.Box, hook *release.Hook, timeout time.Duration) error {
+	o, err := newBoxObject(hook.Manifest, box)
+	if err != nil {
+		return err
+	}
+	if hasHookDeletePolicy(hook, release.HookBeforeHookCreation) {
+		err = deleteHookObject(o, timeout)
 		if err != nil {
 			return err
 		}
-		var describeFunc func(*kubernetes.Clientset, string, string) error
-		switch kind := obj.GetObjectKind().GroupVersionKind().Kind; kind {
-		case structs.KIND_POD:
-			describeFunc = describePod
-		case structs.KIND_POD_TEMPLATE:
-			describeFunc = describePodTemplate
-		case structs.KIND_REPLICATION_CONTROLLER:
-			describeFunc = describeReplicationController
-		case structs.KIND_REPLICA_SET:
-			describeFunc = describeReplicaSet
-		case structs.KIND_DEPLOYMENT:
-			describeFunc = describeDeployment
-		case structs.KIND_STATEFUL_SET:
-			describeFunc = describeStatefulSet
-		case structs.KIND_CONTROLLER_REVISION:
-			describeFunc = describeControllerRevision
-		case structs.KIND_DAEMON_SET:
-			describeFunc = describeDaemonSet
-		case structs.KIND_JOB:
-			describeFunc = describeJob
-		case structs.KIND_CRONJOB:
-			describeFunc = describeCronjob
-		case structs.KIND_HPA:
-			describeFunc = describeHPA
-		case structs.KIND_SERVICE:
-			describeFunc = describeService
-		case structs.KIND_INGRESS:
-			describeFunc = describeIngress
+	}
+	_, err = o.restHelper.Create(o.namespace, false, o.obj)
+	if err != nil {
+		return err
+	}
+
+	err = waitForHook(o, timeout)
+	if err != nil {
+		if hasHookDeletePolicy(hook, release.HookFailed) {
+			deleteHookObject(o, timeout)
 		}
+		return err
+	}
+	if hasHookDeletePolicy(hook, release.HookSucceeded) {
+		return deleteHookObject(o, timeout)
+	}
+	return nil
+}
 
-		name, err := meta.NewAccessor().Name(obj)
-		if err != nil {
-			return err
+// hasHookDeletePolicy checks if the hook has the delete policy. Hooks without policies are deleted before they are created again
+func hasHookDeletePolicy(hook *release.Hook, policy release.HookDeletePolicy) bool {
+	if len(hook.DeletePolicies) == 0 {
+		return policy == release.HookBeforeHookCreation
+	}
+	for _, p := range hook.DeletePolicies {
+		if p == policy {
+			return true
 		}
-		if describeFunc != nil {
-			err = describeFunc(k8sclient, box.Namespace, name)
-			if err != nil {
-				return err
-			}
-			fmt.Println()
+	}
+	return false
+}
+
+// waitForHook waits until the hook job or pod completes. Other kinds are done as soon as they are created
+func waitForHook(o *boxObject, timeout time.Duration) error {
+	kind := o.mapping.GroupVersionKind.Kind
+	if kind != structs.KIND_JOB && kind != structs.KIND_POD {
+		return nil
+	}
+	var reason string
+	err := wait.PollImmediate(readinessPollInterval, timeout, func() (bool, error) {
+		completion := getHookCompletion(k8sclient, kind, o.namespace, o.name)
+		if completion.failed {
+			return false, errors.New(completion.reason)
 		}
+		reason = completion.reason
+		return completion.ready, nil
+	})
+	if wait.Interrupted(err) {
+		return fmt.Errorf("timed out after %s: %s", timeout, reason)
 	}
-	return nil
+	return err
 }
 
-func expandBoxVariables(boxes []structs.Box) []structs.Box {
-	var newBoxes []structs.Box
+// deleteHookObject deletes the hook object together with its pods and waits until it is gone
+func deleteHookObject(o *boxObject, timeout time.Duration) error {
+	propagation := metav1.DeletePropagationBackground
+	_, err := o.restHelper.DeleteWithOptions(o.namespace, o.name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+		_, err := o.restHelper.Get(o.namespace, o.name)
+		if k8serrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+}
 
-	for _, b := range boxes {
-		b.Name = os.ExpandEnv(b.Name)
-		b.Namespace = os.ExpandEnv(b.Namespace)
-		b.Type = os.ExpandEnv(b.Type)
-		b.Chart = os.ExpandEnv(b.Chart)
-		b.Values = os.ExpandEnv(b.Values)
+// boxObject is a box manifest resolved against the cluster API
+type boxObject struct {
+	obj        runtime.Object
+	mapping    *meta.RESTMapping
+	restHelper *resource.Helper
+	namespace  string
+	name       string
+}
+
+// objectKey identifies a k8s object regardless of its manifest
+type objectKey struct {
+	group     string
+	kind      string
+	namespace string
+	name      string
+}
+
+func newBoxObject(manifest string, box structs.Box) (*boxObject, error) {
+	obj, err := utils.CreateRuntimeObject(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping, restHelper, err := clients.restHelper(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := meta.NewAccessor().Name(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := box.Namespace
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		namespace = ""
+	}
+
+	return &boxObject{
+		obj:        obj,
+		mapping:    mapping,
+		restHelper: restHelper,
+		namespace:  namespace,
+		name:       name,
+	}, nil
+}
+
+func (o boxObject) key() objectKey {
+	return objectKey{
+		group:     o.mapping.GroupVersionKind.Group,
+		kind:      o.mapping.GroupVersionKind.Kind,
+		namespace: o.namespace,
+		name:      o.name,
+	}
+}
+
+func (o boxObject) isClusterScoped() bool {
+	return o.mapping.Scope.Name() == meta.RESTScopeNameRoot
+}
+
+// applyBox applies the box objects with server-side apply and prunes the objects that are gone from the previous render
+// The hooks are fired for a box that was deployed before, a new box fires the install hooks.
+func applyBox(box *structs.Box, environment structs.Environment, previous *structs.Box, tracker *objectTracker, hooks applyHooks, deadline time.Time) ([]*runtime.Object, error) {
+	var objects []*runtime.Object
+
+	err := installBoxCRDs(*box)
+	if err != nil {
+		return nil, err
+	}
+
+	if previous == nil {
+		hooks = installHooks
+	}
+	err = runBoxHooks(*box, hooks.pre, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	previousManifests := make(map[objectKey]string)
+	if previous != nil {
+		for _, rend := range utils.GetUninstallManifests(previous.HelmRender) {
+			o, err := newBoxObject(rend, *previous)
+			if meta.IsNoMatchError(err) {
+				// the kind is not served anymore, the new render can't have the same object
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			previousManifests[o.key()] = rend
+		}
+	}
+
+	force := true
+	for _, rend := range utils.GetInstallManifests(box.HelmRender) {
+		o, err := newBoxObject(rend, *box)
+		if err != nil {
+			return nil, err
+		}
+
+		var clusterObject *structs.ClusterObject
+		if o.isClusterScoped() {
+			co, err := newClusterObject(o.mapping, o.obj)
+			if err != nil {
+				return nil, err
+			}
+			owner, err := findClusterObjectOwner(environment, co)
+			if err != nil {
+				return nil, err
+			}
+			if owner != nil {
+				return nil, fmt.Errorf("Cluster-scoped %s %s already belongs to the environment %s (namespace %s)", co.Kind, co.Name, owner.ID, owner.Namespace)
+			}
+			clusterObject = &co
+		}
+
+		if previousManifests[o.key()] == rend {
+			// leave the unchanged object alone if it is still there, it keeps the revision that changed it last
+			_, err := o.restHelper.Get(o.namespace, o.name)
+			if err == nil {
+				if clusterObject != nil {
+					box.ClusterObjects = append(box.ClusterObjects, *clusterObject)
+				}
+				continue
+			}
+			if !k8serrors.IsNotFound(err) {
+				return nil, err
+			}
+		}
+
+		data, err := getApplyPatch(rend, environment.Revision)
+		if err != nil {
+			return nil, err
+		}
+		rtobj, err := o.restHelper.WithFieldManager(FIELD_MANAGER).Patch(o.namespace, o.name, types.ApplyPatchType, data, &metav1.PatchOptions{Force: &force})
+		if err != nil {
+			return nil, err
+		}
+		tracker.track(box.Name, o)
+		if clusterObject != nil {
+			box.ClusterObjects = append(box.ClusterObjects, *clusterObject)
+		}
+		objects = append(objects, &rtobj)
+	}
+
+	err = runBoxPostHooks(*box, hooks.post, deadline)
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func dryRunBox(box structs.Box, strategy structs.DryRunStrategy) ([]structs.ObjectReport, error) {
+	var reports []structs.ObjectReport
+
+	namespaceExists := true
+	if strategy == structs.DRY_RUN_SERVER {
+		_, err := k8sclient.CoreV1().Namespaces().Get(context.Background(), box.Namespace, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			namespaceExists = false
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, rend := range utils.GetBoxManifests(box) {
+		report := structs.ObjectReport{Box: box.Name, Namespace: box.Namespace}
+		obj, err := utils.CreateRuntimeObject(rend)
+		if err != nil {
+			report.Action = structs.ACTION_REJECT
+			report.Reason = err.Error()
+			reports = append(reports, report)
+			continue
+		}
+		report.Kind = obj.GetObjectKind().GroupVersionKind().Kind
+		report.Name, _ = meta.NewAccessor().Name(obj)
+
+		if strategy == structs.DRY_RUN_CLIENT {
+			report.Action = structs.ACTION_CREATE
+			report.Reason = "Not checked against the cluster"
+			reports = append(reports, report)
+			continue
+		}
+
+		o, err := newBoxObject(rend, box)
+		if err != nil {
+			report.Action = structs.ACTION_REJECT
+			report.Reason = err.Error()
+			if meta.IsNoMatchError(err) && isKindDefinedByBoxCRDs(box, obj.GetObjectKind().GroupVersionKind().GroupKind()) {
+				report.Action = structs.ACTION_CREATE
+				report.Reason = "The kind is defined by a CRD of the box, validated client-side only"
+			}
+			reports = append(reports, report)
+			continue
+		}
+		report.Namespace = o.namespace
+		report.Action, report.Reason = previewObject(o, rend, namespaceExists || o.isClusterScoped())
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// previewObject sends the object to the API server with DryRun=All and reports the outcome
+func previewObject(o *boxObject, manifest string, namespaceExists bool) (structs.ObjectAction, string) {
+	live, err := o.restHelper.Get(o.namespace, o.name)
+	if k8serrors.IsNotFound(err) {
+		if !namespaceExists {
+			return structs.ACTION_CREATE, fmt.Sprintf("Namespace %s doesn't exist yet, validated client-side only", o.namespace)
+		}
+		_, err = o.restHelper.DryRun(true).Create(o.namespace, false, o.obj)
+		if err != nil {
+			return structs.ACTION_REJECT, err.Error()
+		}
+		return structs.ACTION_CREATE, ""
+	}
+	if err != nil {
+		return structs.ACTION_REJECT, err.Error()
+	}
+
+	result, err := dryRunApply(o, manifest)
+	if err != nil {
+		return structs.ACTION_REJECT, err.Error()
+	}
+	changed, err := isObjectChanged(live, result)
+	if err != nil {
+		return structs.ACTION_REJECT, err.Error()
+	}
+	if changed {
+		return structs.ACTION_CHANGE, ""
+	}
+	return structs.ACTION_UNCHANGED, ""
+}
+
+// dryRunApply returns the object the API server would store after a server-side apply of the manifest
+func dryRunApply(o *boxObject, manifest string) (runtime.Object, error) {
+	data, err := yaml.YAMLToJSON([]byte(manifest))
+	if err != nil {
+		return nil, err
+	}
+	force := true
+	return o.restHelper.DryRun(true).WithFieldManager(FIELD_MANAGER).Patch(o.namespace, o.name, types.ApplyPatchType, data, &metav1.PatchOptions{Force: &force})
+}
+
+// diffBox compares every object of the box with the live one and lists the objects that are gone from the previous render
+func diffBox(box structs.Box, previous *structs.Box) ([]structs.ObjectReport, error) {
+	var reports []structs.ObjectReport
+
+	namespaceExists := true
+	_, err := k8sclient.CoreV1().Namespaces().Get(context.Background(), box.Namespace, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		namespaceExists = false
+	} else if err != nil {
+		return nil, err
+	}
+
+	rendered := make(map[objectKey]bool)
+	for _, rend := range utils.GetBoxManifests(box) {
+		obj, err := utils.CreateRuntimeObject(rend)
+		if err != nil {
+			return nil, err
+		}
+		report := structs.ObjectReport{Box: box.Name, Namespace: box.Namespace, Kind: obj.GetObjectKind().GroupVersionKind().Kind}
+		report.Name, _ = meta.NewAccessor().Name(obj)
+
+		o, err := newBoxObject(rend, box)
+		if err != nil {
+			if !meta.IsNoMatchError(err) || !isKindDefinedByBoxCRDs(box, obj.GetObjectKind().GroupVersionKind().GroupKind()) {
+				return nil, err
+			}
+			// the kind can't be served before the CRD of the box is installed
+			report.Action = structs.ACTION_CREATE
+			report.Diff, err = diffObjects(report, nil, obj)
+			if err != nil {
+				return nil, err
+			}
+			reports = append(reports, report)
+			continue
+		}
+		rendered[o.key()] = true
+		report.Namespace = o.namespace
+
+		var desired runtime.Object
+		live, err := o.restHelper.Get(o.namespace, o.name)
+		if k8serrors.IsNotFound(err) {
+			live = nil
+			report.Action = structs.ACTION_CREATE
+			desired = o.obj
+			if namespaceExists || o.isClusterScoped() {
+				desired, err = o.restHelper.DryRun(true).Create(o.namespace, false, o.obj)
+			}
+		} else if err == nil {
+			desired, err = dryRunApply(o, rend)
+		}
+		if err != nil {
+			report.Action = structs.ACTION_REJECT
+			report.Reason = err.Error()
+			reports = append(reports, report)
+			continue
+		}
+
+		report.Diff, err = diffObjects(report, live, desired)
+		if err != nil {
+			return nil, err
+		}
+		if live != nil {
+			report.Action = structs.ACTION_UNCHANGED
+			if len(report.Diff) > 0 {
+				report.Action = structs.ACTION_CHANGE
+			}
+		}
+		reports = append(reports, report)
+	}
+
+	if previous != nil {
+		removed, err := diffRemovedObjects(*previous, rendered)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, removed...)
+	}
+	return reports, nil
+}
+
+// diffRemovedObjects reports the live objects of the saved box which are not rendered anymore
+func diffRemovedObjects(box structs.Box, rendered map[objectKey]bool) ([]structs.ObjectReport, error) {
+	var reports []structs.ObjectReport
+	for _, rend := range utils.GetUninstallManifests(box.HelmRender) {
+		o, err := newBoxObject(rend, box)
+		if err != nil {
+			return nil, err
+		}
+		if rendered[o.key()] {
+			continue
+		}
+		live, err := o.restHelper.Get(o.namespace, o.name)
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		report := structs.ObjectReport{
+			Box:       box.Name,
+			Kind:      o.mapping.GroupVersionKind.Kind,
+			Namespace: o.namespace,
+			Name:      o.name,
+			Action:    structs.ACTION_DELETE,
+		}
+		report.Diff, err = diffObjects(report, live, nil)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// diffObjects makes a unified diff between the live and the desired objects, ignoring the fields populated by the API server
+func diffObjects(report structs.ObjectReport, live runtime.Object, desired runtime.Object) (string, error) {
+	var err error
+	liveYaml, desiredYaml := []byte{}, []byte{}
+	if live != nil {
+		liveYaml, err = sanitizedYaml(live)
+		if err != nil {
+			return "", err
+		}
+	}
+	if desired != nil {
+		desiredYaml, err = sanitizedYaml(desired)
+		if err != nil {
+			return "", err
+		}
+	}
+	path := strings.Join([]string{report.Kind, report.Namespace, report.Name}, "/")
+	if len(report.Namespace) == 0 {
+		path = strings.Join([]string{report.Kind, report.Name}, "/")
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(liveYaml)),
+		B:        difflib.SplitLines(string(desiredYaml)),
+		FromFile: "live/" + path,
+		ToFile:   "k8sbox/" + path,
+		Context:  3,
+	})
+}
+
+func sanitizedYaml(obj runtime.Object) ([]byte, error) {
+	content, err := sanitizeObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(content)
+}
+
+func isObjectChanged(live runtime.Object, desired runtime.Object) (bool, error) {
+	liveContent, err := sanitizeObject(live)
+	if err != nil {
+		return false, err
+	}
+	desiredContent, err := sanitizeObject(desired)
+	if err != nil {
+		return false, err
+	}
+	return !equality.Semantic.DeepEqual(liveContent, desiredContent), nil
+}
+
+// sanitizeObject converts the object to a map without the fields populated by the API server
+func sanitizeObject(obj runtime.Object) (map[string]interface{}, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	content = runtime.DeepCopyJSON(content)
+	unstructured.RemoveNestedField(content, "status")
+	for _, field := range []string{"managedFields", "resourceVersion", "generation", "uid", "creationTimestamp", "selfLink"} {
+		unstructured.RemoveNestedField(content, "metadata", field)
+	}
+	// the revision changes with every deploy, it is not a change of the object
+	unstructured.RemoveNestedField(content, "metadata", "annotations", structs.ANNOTATION_REVISION)
+	if annotations, ok, _ := unstructured.NestedMap(content, "metadata", "annotations"); ok && len(annotations) == 0 {
+		unstructured.RemoveNestedField(content, "metadata", "annotations")
+	}
+	return content, nil
+}
+
+// getApplyPatch will convert the manifest to a server-side apply patch annotated with the revision
+func getApplyPatch(manifest string, revision int) ([]byte, error) {
+	data, err := yaml.YAMLToJSON([]byte(manifest))
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{}
+	err = obj.UnmarshalJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	err = setRevisionAnnotation(obj, revision)
+	if err != nil {
+		return nil, err
+	}
+	return obj.MarshalJSON()
+}
+
+// setRevisionAnnotation will annotate the object with the revision of the environment that deploys it
+func setRevisionAnnotation(obj runtime.Object, revision int) error {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	annotations := accessor.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[structs.ANNOTATION_REVISION] = strconv.Itoa(revision)
+	accessor.SetAnnotations(annotations)
+	return nil
+}
+
+func isKindDefinedByBoxCRDs(box structs.Box, gk schema.GroupKind) bool {
+	for _, rend := range utils.ConvertHelmRenderToYaml(box.CRDRender) {
+		obj, err := utils.CreateRuntimeObject(rend)
+		if err != nil {
+			continue
+		}
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			continue
+		}
+		group, _, _ := unstructured.NestedString(content, "spec", "group")
+		kind, _, _ := unstructured.NestedString(content, "spec", "names", "kind")
+		if group == gk.Group && kind == gk.Kind {
+			return true
+		}
+	}
+	return false
+}
+
+func installBoxCRDs(box structs.Box) error {
+	r := utils.ConvertHelmRenderToYaml(box.CRDRender)
+	for _, rend := range r {
+		obj, err := utils.CreateRuntimeObject(rend)
+		if err != nil {
+			return err
+		}
+
+		_, restHelper, err := clients.restHelper(obj)
+		if err != nil {
+			return err
+		}
+
+		// Existing CRDs are never updated, the same way helm treats them
+		_, err = restHelper.Create("", false, obj)
+		if err != nil && !k8serrors.IsAlreadyExists(err) {
+			return err
+		}
+
+		err = waitForCRD(restHelper, obj)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForCRD waits until the CRD is established and its kinds are served by the API discovery
+func waitForCRD(restHelper *resource.Helper, crd runtime.Object) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(crd)
+	if err != nil {
+		return err
+	}
+	name, _, _ := unstructured.NestedString(content, "metadata", "name")
+	group, _, _ := unstructured.NestedString(content, "spec", "group")
+	kind, _, _ := unstructured.NestedString(content, "spec", "names", "kind")
+	versions, _, _ := unstructured.NestedSlice(content, "spec", "versions")
+
+	err = wait.PollImmediate(time.Second, crdEstablishTimeout, func() (bool, error) {
+		live, err := restHelper.Get("", name)
+		if err != nil {
+			return false, err
+		}
+		if !isCRDEstablished(live) {
+			return false, nil
+		}
+		for _, v := range versions {
+			version, ok := v.(map[string]interface{})
+			if !ok || version["served"] != true {
+				continue
+			}
+			probe := &unstructured.Unstructured{}
+			probe.SetGroupVersionKind(schema.GroupVersionKind{Group: group, Version: fmt.Sprintf("%v", version["name"]), Kind: kind})
+			_, err := clients.restMapping(probe)
+			if err != nil {
+				// the discovery doesn't know about the new kind yet
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("CRD %s is not established: %s", name, err)
+	}
+	return nil
+}
+
+func isCRDEstablished(crd runtime.Object) bool {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(crd)
+	if err != nil {
+		return false
+	}
+	conditions, _, _ := unstructured.NestedSlice(content, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && condition["type"] == "Established" && condition["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+func uninstallBoxCRDs(environment structs.Environment, box structs.Box) error {
+	r := utils.ConvertHelmRenderToYaml(box.CRDRender)
+	for _, rend := range r {
+		obj, err := utils.CreateRuntimeObject(rend)
+		if err != nil {
+			return err
+		}
+
+		name, err := meta.NewAccessor().Name(obj)
+		if err != nil {
+			return err
+		}
+
+		used, err := isCRDUsedByAnotherEnvironment(environment, name)
+		if err != nil {
+			return err
+		}
+		if used {
+			continue
+		}
+
+		_, restHelper, err := clients.restHelper(obj)
+		if err != nil {
+			return err
+		}
+
+		_, err = restHelper.Delete("", name)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func isCRDUsedByAnotherEnvironment(environment structs.Environment, name string) (bool, error) {
+	environments, err := getAllSavedEnvironments()
+	if err != nil {
+		return false, err
+	}
+	for _, env := range environments {
+		if env.ID == environment.ID && env.Namespace == environment.Namespace {
+			continue
+		}
+		for _, box := range env.Boxes {
+			for _, rend := range utils.ConvertHelmRenderToYaml(box.CRDRender) {
+				obj, err := utils.CreateRuntimeObject(rend)
+				if err != nil {
+					return false, err
+				}
+				crdName, err := meta.NewAccessor().Name(obj)
+				if err != nil {
+					return false, err
+				}
+				if crdName == name {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+func newClusterObject(mapping *meta.RESTMapping, obj runtime.Object) (structs.ClusterObject, error) {
+	name, err := meta.NewAccessor().Name(obj)
+	if err != nil {
+		return structs.ClusterObject{}, err
+	}
+	return structs.ClusterObject{
+		Group: mapping.GroupVersionKind.Group,
+		Kind:  mapping.GroupVersionKind.Kind,
+		Name:  name,
+	}, nil
+}
+
+// findClusterObjectOwner looks for another saved environment that has already created the cluster-scoped object
+func findClusterObjectOwner(environment structs.Environment, clusterObject structs.ClusterObject) (*structs.Environment, error) {
+	environments, err := getAllSavedEnvironments()
+	if err != nil {
+		return nil, err
+	}
+	for _, env := range environments {
+		if env.ID == environment.ID && env.Namespace == environment.Namespace {
+			continue
+		}
+		for _, box := range env.Boxes {
+			for _, co := range box.ClusterObjects {
+				if co == clusterObject {
+					return &env, nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+func uninstallBox(environment structs.Environment, box structs.Box) ([]*runtime.Object, error) {
+	return uninstallBoxExcept(environment, box, nil)
+}
+
+// uninstallBoxExcept uninstalls the box, the objects of the keep set are left alone, e.g. the ones moved to another box
+func uninstallBoxExcept(environment structs.Environment, box structs.Box, keep map[objectKey]bool) ([]*runtime.Object, error) {
+	objects, err := uninstallBoxObjects(environment, box, keep)
+	if err != nil {
+		return nil, err
+	}
+	err = deleteSavedBox(environment, box)
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// uninstallBoxObjects deletes the objects of the box between its delete hooks, the box stays saved
+func uninstallBoxObjects(environment structs.Environment, box structs.Box, keep map[objectKey]bool) ([]*runtime.Object, error) {
+	err := runBoxHooks(box, release.HookPreDelete, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := deleteBoxObjects(environment, box, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	err = runBoxHooks(box, release.HookPostDelete, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// deleteBoxObjects deletes the objects of the box render except for the ones of the keep set.
+// Cluster-scoped objects are deleted only if the box has created them and no other environment owns them.
+// Objects of the kinds the cluster doesn't serve anymore are skipped with a warning.
+func deleteBoxObjects(environment structs.Environment, box structs.Box, keep map[objectKey]bool) ([]*runtime.Object, error) {
+	var objects []*runtime.Object
+	for _, rend := range utils.GetUninstallManifests(box.HelmRender) {
+		obj, err := utils.CreateRuntimeObject(rend)
+		if err != nil {
+			return nil, err
+		}
+
+		mapping, restHelper, err := clients.restHelper(obj)
+		if meta.IsNoMatchError(err) {
+			log.Printf("Warning: box %s: %s is not served by the cluster anymore, its objects are left alone", box.Name, obj.GetObjectKind().GroupVersionKind())
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := meta.NewAccessor().Name(obj)
+		if err != nil {
+			return nil, err
+		}
+
+		namespace := box.Namespace
+		if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+			namespace = ""
+		}
+		gvk := mapping.GroupVersionKind
+		if keep[objectKey{group: gvk.Group, kind: gvk.Kind, namespace: namespace, name: name}] {
+			continue
+		}
+		if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+			clusterObject, err := newClusterObject(mapping, obj)
+			if err != nil {
+				return nil, err
+			}
+			if !hasClusterObject(box, clusterObject) {
+				// the object was there before the box, leave it alone
+				continue
+			}
+			owner, err := findClusterObjectOwner(environment, clusterObject)
+			if err != nil {
+				return nil, err
+			}
+			if owner != nil {
+				// the object belongs to another environment, leave it alone
+				continue
+			}
+		}
+
+		rtobj, err := restHelper.Delete(namespace, name)
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, &rtobj)
+	}
+	return objects, nil
+}
+
+func describeBoxApplications(environment structs.Environment, box structs.Box) error {
+	r := utils.GetInstallManifests(box.HelmRender)
+	for _, rend := range r {
+		obj, err := utils.CreateRuntimeObject(rend)
+		if err != nil {
+			return err
+		}
+		var describeFunc func(*kubernetes.Clientset, string, string) error
+		switch kind := obj.GetObjectKind().GroupVersionKind().Kind; kind {
+		case structs.KIND_POD:
+			describeFunc = describePod
+		case structs.KIND_POD_TEMPLATE:
+			describeFunc = describePodTemplate
+		case structs.KIND_REPLICATION_CONTROLLER:
+			describeFunc = describeReplicationController
+		case structs.KIND_REPLICA_SET:
+			describeFunc = describeReplicaSet
+		case structs.KIND_DEPLOYMENT:
+			describeFunc = describeDeployment
+		case structs.KIND_STATEFUL_SET:
+			describeFunc = describeStatefulSet
+		case structs.KIND_CONTROLLER_REVISION:
+			describeFunc = describeControllerRevision
+		case structs.KIND_DAEMON_SET:
+			describeFunc = describeDaemonSet
+		case structs.KIND_JOB:
+			describeFunc = describeJob
+		case structs.KIND_CRONJOB:
+			describeFunc = describeCronjob
+		case structs.KIND_HPA:
+			describeFunc = describeHPA
+		case structs.KIND_SERVICE:
+			describeFunc = describeService
+		case structs.KIND_INGRESS:
+			describeFunc = describeIngress
+		}
+
+		name, err := meta.NewAccessor().Name(obj)
+		if err != nil {
+			return err
+		}
+		if describeFunc != nil {
+			err = describeFunc(k8sclient, box.Namespace, name)
+			if err != nil {
+				return err
+			}
+			fmt.Println()
+			continue
+		}
+		if _, ok := obj.(*unstructured.Unstructured); ok {
+			err = describeCustomResource(obj, box.Namespace)
+			if err != nil {
+				return err
+			}
+			fmt.Println()
+		}
+	}
+	return nil
+}
+
+// getBoxReadiness checks every box object that k8sbox knows how to wait for
+func getBoxReadiness(box structs.Box) (structs.BoxReadiness, error) {
+	readiness := structs.BoxReadiness{Box: box.Name}
+	for _, manifest := range utils.GetInstallManifests(box.HelmRender) {
+		obj, err := utils.CreateRuntimeObject(manifest)
+		if err != nil {
+			return readiness, err
+		}
+		kind := obj.GetObjectKind().GroupVersionKind().Kind
+		if !isWaitableKind(kind) {
+			continue
+		}
+		name, err := meta.NewAccessor().Name(obj)
+		if err != nil {
+			return readiness, err
+		}
+
+		readiness.Total++
+		r := getObjectReadiness(k8sclient, kind, box.Namespace, name)
+		switch {
+		case r.ready:
+			readiness.Ready++
+		case r.failed:
+			readiness.Failed = append(readiness.Failed, fmt.Sprintf("%s %s: %s", kind, name, r.reason))
+		default:
+			readiness.Pending = append(readiness.Pending, fmt.Sprintf("%s %s: %s", kind, name, r.reason))
+		}
+	}
+	return readiness, nil
+}
+
+// getBoxObjectStatuses checks the live state of every object from the box render
+func getBoxObjectStatuses(box structs.Box) ([]structs.ObjectStatus, error) {
+	var statuses []structs.ObjectStatus
+	for _, manifest := range utils.GetInstallManifests(box.HelmRender) {
+		o, err := newBoxObject(manifest, box)
+		if err != nil {
+			return nil, err
+		}
+		kind := o.mapping.GroupVersionKind.Kind
+		status := structs.ObjectStatus{
+			Box:       box.Name,
+			Kind:      kind,
+			Namespace: o.namespace,
+			Name:      o.name,
+			State:     structs.OBJECT_PRESENT,
+		}
+		live, err := o.restHelper.Get(o.namespace, o.name)
+		if k8serrors.IsNotFound(err) {
+			status.State = structs.OBJECT_MISSING
+			statuses = append(statuses, status)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if accessor, err := meta.Accessor(live); err == nil {
+			createdAt := accessor.GetCreationTimestamp().Time
+			status.CreatedAt = &createdAt
+		}
+		if isWaitableKind(kind) {
+			readiness := getObjectReadiness(k8sclient, kind, o.namespace, o.name)
+			switch {
+			case readiness.ready:
+				status.State = structs.OBJECT_READY
+			case readiness.failed:
+				status.State = structs.OBJECT_FAILED
+			default:
+				status.State = structs.OBJECT_PENDING
+			}
+			status.Reason = readiness.reason
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// diagnoseBox explains why the box objects are not ready using the pod statuses, the warning events and the container logs
+func diagnoseBox(box structs.Box) (structs.BoxDiagnosis, error) {
+	diagnosis := structs.BoxDiagnosis{Box: box.Name}
+	events, err := k8sclient.CoreV1().Events(box.Namespace).List(context.Background(), metav1.ListOptions{FieldSelector: "type=Warning"})
+	if err != nil {
+		return diagnosis, err
+	}
+
+	for _, manifest := range utils.GetInstallManifests(box.HelmRender) {
+		obj, err := utils.CreateRuntimeObject(manifest)
+		if err != nil {
+			return diagnosis, err
+		}
+		kind := obj.GetObjectKind().GroupVersionKind().Kind
+		if !isWaitableKind(kind) && kind != structs.KIND_POD {
+			continue
+		}
+		name, err := meta.NewAccessor().Name(obj)
+		if err != nil {
+			return diagnosis, err
+		}
+
+		readiness := getObjectReadiness(k8sclient, kind, box.Namespace, name)
+		if readiness.ready && kind != structs.KIND_POD {
+			continue
+		}
+		objectDiagnosis, err := diagnoseObject(k8sclient, kind, box.Namespace, name, readiness.reason, events.Items)
+		if err != nil {
+			return diagnosis, err
+		}
+		if objectDiagnosis != nil {
+			diagnosis.Objects = append(diagnosis.Objects, *objectDiagnosis)
+		}
+	}
+	return diagnosis, nil
+}
+
+// waitForBoxes waits until every object of the boxes is ready, one of them fails or the timeout hits.
+// The progress is reported after every check.
+func waitForBoxes(boxes []structs.Box, timeout time.Duration, progress func([]structs.BoxReadiness)) error {
+	if timeout <= 0 {
+		// a zero timeout would make the poll wait forever, so the boxes are checked only once
+		timeout = time.Millisecond
+	}
+
+	var states []structs.BoxReadiness
+	failed := false
+	err := wait.PollImmediate(readinessPollInterval, timeout, func() (bool, error) {
+		states = nil
+		ready := true
+		for _, box := range boxes {
+			state, err := getBoxReadiness(box)
+			if err != nil {
+				return false, err
+			}
+			states = append(states, state)
+			ready = ready && state.IsReady()
+			failed = failed || len(state.Failed) > 0
+		}
+		if progress != nil {
+			progress(states)
+		}
+		return ready || failed, nil
+	})
+	if wait.Interrupted(err) {
+		return fmt.Errorf("Timed out after %s waiting for:\n\r%s", timeout.Round(time.Second), describeNotReadyBoxes(states))
+	}
+	if err != nil {
+		return err
+	}
+	if failed {
+		return fmt.Errorf("Some objects will never become ready:\n\r%s", describeNotReadyBoxes(states))
+	}
+	return nil
+}
+
+func describeNotReadyBoxes(states []structs.BoxReadiness) string {
+	var messages []string
+	for _, state := range states {
+		for _, f := range state.Failed {
+			messages = append(messages, fmt.Sprintf("-> %s: %s", state.Box, f))
+		}
+		for _, p := range state.Pending {
+			messages = append(messages, fmt.Sprintf("-> %s: %s", state.Box, p))
+		}
+	}
+	return strings.Join(messages, "\n\r")
+}
+
+func expandBoxVariables(boxes []structs.Box) []structs.Box {
+	var newBoxes []structs.Box
+
+	for _, b := range boxes {
+		b.Name = os.ExpandEnv(b.Name)
+		b.Namespace = os.ExpandEnv(b.Namespace)
+		b.Type = os.ExpandEnv(b.Type)
+		b.Chart = os.ExpandEnv(b.Chart)
+		b.Values = os.ExpandEnv(b.Values)
+		for i, valuesFile := range b.ValuesFiles {
+			b.ValuesFiles[i] = os.ExpandEnv(valuesFile)
+		}
+		for i, dependency := range b.DependsOn {
+			b.DependsOn[i] = os.ExpandEnv(dependency)
+		}
 		b.Applications = ExpandApplications(b.Applications)
 		newBoxes = append(newBoxes, b)
 	}
@@ -0,0 +1,777 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/utils"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/strings/slices"
+)
+
+func TestMergeValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     map[string]interface{}
+		override map[string]interface{}
+		want     map[string]interface{}
+	}{
+		{
+			name:     "empty override",
+			base:     map[string]interface{}{"replicas": 1},
+			override: map[string]interface{}{},
+			want:     map[string]interface{}{"replicas": 1},
+		},
+		{
+			name:     "scalar is replaced",
+			base:     map[string]interface{}{"replicas": 1, "name": "web"},
+			override: map[string]interface{}{"replicas": 3},
+			want:     map[string]interface{}{"replicas": 3, "name": "web"},
+		},
+		{
+			name: "tables are merged deep",
+			base: map[string]interface{}{
+				"image": map[string]interface{}{"repository": "nginx", "tag": "1.24"},
+			},
+			override: map[string]interface{}{
+				"image": map[string]interface{}{"tag": "1.25"},
+			},
+			want: map[string]interface{}{
+				"image": map[string]interface{}{"repository": "nginx", "tag": "1.25"},
+			},
+		},
+		{
+			name:     "table replaces a scalar",
+			base:     map[string]interface{}{"resources": "none"},
+			override: map[string]interface{}{"resources": map[string]interface{}{"cpu": "100m"}},
+			want:     map[string]interface{}{"resources": map[string]interface{}{"cpu": "100m"}},
+		},
+		{
+			name:     "lists are replaced",
+			base:     map[string]interface{}{"args": []interface{}{"a", "b"}},
+			override: map[string]interface{}{"args": []interface{}{"c"}},
+			want:     map[string]interface{}{"args": []interface{}{"c"}},
+		},
+		{
+			name:     "null is kept for helm to remove the default",
+			base:     map[string]interface{}{"probe": map[string]interface{}{"path": "/"}},
+			override: map[string]interface{}{"probe": nil},
+			want:     map[string]interface{}{"probe": nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeValues(tt.base, tt.override)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeValues() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateHelmRendersExpandsChartValues(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Chart.yaml":  "apiVersion: v2\nname: web\nversion: 0.1.0\n",
+		"values.yaml": "image: ${IMAGE}\nreplicas: 1\n",
+		"templates/config.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  image: {{ .Values.image }}
+  replicas: "{{ .Values.replicas }}"
+`,
+		"box-values.yaml": "replicas: ${REPLICAS}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0750)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	variables := map[string]string{"IMAGE": "nginx:1.25", "REPLICAS": "3"}
+	box := structs.Box{
+		Name:      "web",
+		Namespace: "default",
+		Chart:     filepath.Join(dir, "Chart.yaml"),
+		Values:    filepath.Join(dir, "box-values.yaml"),
+	}
+	err := createHelmRenders(structs.Environment{}, &box, func(key string) string {
+		return variables[key]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	render := box.HelmRender["web/templates/config.yaml"]
+	if !strings.Contains(render, "image: nginx:1.25") {
+		t.Errorf("the chart default values are not expanded:\n%s", render)
+	}
+	if !strings.Contains(render, `replicas: "3"`) {
+		t.Errorf("the box values are not expanded:\n%s", render)
+	}
+}
+
+func TestValidateBoxes(t *testing.T) {
+	manifest := filepath.Join(t.TempDir(), "redis.yaml")
+	err := os.WriteFile(manifest, []byte("kind: ConfigMap\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := func(name string, dependsOn ...string) structs.Box {
+		return structs.Box{
+			Name:         name,
+			Type:         structs.Plain(),
+			DependsOn:    dependsOn,
+			Applications: []structs.Application{{Name: "redis", Chart: manifest}},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		boxes []structs.Box
+		want  []string
+	}{
+		{
+			name:  "unique names",
+			boxes: []structs.Box{plain("db"), plain("api", "db")},
+		},
+		{
+			name:  "boxes without a name",
+			boxes: []structs.Box{plain(""), plain("")},
+		},
+		{
+			name:  "duplicate names",
+			boxes: []structs.Box{plain("db"), plain("api"), plain("db")},
+			want:  []string{"-> Box 2: Name db is already used by box 0"},
+		},
+		{
+			name:  "unknown dependency",
+			boxes: []structs.Box{plain("api", "db")},
+			want:  []string{"-> Box 0: Depends on an unknown box db"},
+		},
+		{
+			name:  "dependency cycle",
+			boxes: []structs.Box{plain("db", "api"), plain("api", "db")},
+			want:  []string{"-> Box dependencies contain a cycle: db -> api -> db"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBoxes(tt.boxes)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("validateBoxes() error = %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("validateBoxes() error = nil, want %q", tt.want)
+			}
+			messages := strings.Split(err.Error(), "\n\r")
+			if !reflect.DeepEqual(messages, tt.want) {
+				t.Errorf("validateBoxes() = %q, want %q", messages, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandManifest(t *testing.T) {
+	variables := map[string]string{"NAME": "web", "EMPTY": ""}
+	tests := []struct {
+		name     string
+		manifest string
+		want     string
+	}{
+		{name: "defined variable", manifest: "name: $NAME", want: "name: web"},
+		{name: "defined variable in braces", manifest: "name: ${NAME}-svc", want: "name: web-svc"},
+		{name: "defined empty variable", manifest: "value: \"${EMPTY}\"", want: "value: \"\""},
+		{name: "unknown variable is kept", manifest: "value: $UNKNOWN", want: "value: $UNKNOWN"},
+		{name: "unknown variable in braces is kept", manifest: "value: ${HOME}/data", want: "value: ${HOME}/data"},
+		{name: "kubernetes reference is kept", manifest: "args: [\"--name=$(POD_NAME)\"]", want: "args: [\"--name=$(POD_NAME)\"]"},
+		{name: "shell script is kept", manifest: "run.sh: echo \"$1\" && for f in $@; do echo $f; done", want: "run.sh: echo \"$1\" && for f in $@; do echo $f; done"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expandManifest(tt.manifest, variables); got != tt.want {
+				t.Errorf("expandManifest() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreatePlainRendersKeepsUnknownVariables(t *testing.T) {
+	t.Setenv("HOME", "/home/k8sbox")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ${NAME}
+data:
+  run.sh: cd $HOME && echo $UNKNOWN
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	box := structs.Box{Applications: []structs.Application{{Name: "config", Chart: path}}}
+	err = createPlainRenders(&box, map[string]string{"NAME": "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	render := box.HelmRender["config"]
+	if !strings.Contains(render, "name: web") {
+		t.Errorf("the defined variable is not expanded:\n%s", render)
+	}
+	if !strings.Contains(render, "run.sh: cd $HOME && echo $UNKNOWN") {
+		t.Errorf("the unknown variables are not kept:\n%s", render)
+	}
+}
+
+func TestHasHookDeletePolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []release.HookDeletePolicy
+		policy   release.HookDeletePolicy
+		want     bool
+	}{
+		{name: "no policies default to before-hook-creation", policy: release.HookBeforeHookCreation, want: true},
+		{name: "no policies keep succeeded hooks", policy: release.HookSucceeded, want: false},
+		{name: "no policies keep failed hooks", policy: release.HookFailed, want: false},
+		{
+			name:     "listed policy",
+			policies: []release.HookDeletePolicy{release.HookSucceeded, release.HookFailed},
+			policy:   release.HookFailed,
+			want:     true,
+		},
+		{
+			name:     "explicit policies replace the default",
+			policies: []release.HookDeletePolicy{release.HookSucceeded},
+			policy:   release.HookBeforeHookCreation,
+			want:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &release.Hook{DeletePolicies: tt.policies}
+			if got := hasHookDeletePolicy(hook, tt.policy); got != tt.want {
+				t.Errorf("hasHookDeletePolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetHookTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline time.Time
+		min, max time.Duration
+	}{
+		{name: "no deadline", min: hookTimeout, max: hookTimeout},
+		{name: "distant deadline", deadline: time.Now().Add(time.Hour), min: hookTimeout, max: hookTimeout},
+		{name: "close deadline", deadline: time.Now().Add(time.Minute), min: 59 * time.Second, max: time.Minute},
+		{name: "passed deadline", deadline: time.Now().Add(-time.Minute), min: readinessPollInterval, max: readinessPollInterval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getHookTimeout(tt.deadline)
+			if got < tt.min || got > tt.max {
+				t.Errorf("getHookTimeout() = %s, want between %s and %s", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestBoxObjectKey(t *testing.T) {
+	tests := []struct {
+		name              string
+		mapping           *meta.RESTMapping
+		namespace         string
+		want              objectKey
+		wantClusterScoped bool
+	}{
+		{
+			name: "namespaced object",
+			mapping: &meta.RESTMapping{
+				GroupVersionKind: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+				Scope:            meta.RESTScopeNamespace,
+			},
+			namespace: "default",
+			want:      objectKey{group: "apps", kind: "Deployment", namespace: "default", name: "web"},
+		},
+		{
+			name: "core group object",
+			mapping: &meta.RESTMapping{
+				GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+				Scope:            meta.RESTScopeNamespace,
+			},
+			namespace: "default",
+			want:      objectKey{kind: "ConfigMap", namespace: "default", name: "web"},
+		},
+		{
+			name: "cluster-scoped object",
+			mapping: &meta.RESTMapping{
+				GroupVersionKind: schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"},
+				Scope:            meta.RESTScopeRoot,
+			},
+			want:              objectKey{group: "rbac.authorization.k8s.io", kind: "ClusterRole", name: "web"},
+			wantClusterScoped: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := boxObject{mapping: tt.mapping, namespace: tt.namespace, name: "web"}
+			if got := o.key(); got != tt.want {
+				t.Errorf("key() = %+v, want %+v", got, tt.want)
+			}
+			if got := o.isClusterScoped(); got != tt.wantClusterScoped {
+				t.Errorf("isClusterScoped() = %v, want %v", got, tt.wantClusterScoped)
+			}
+		})
+	}
+}
+
+// newTestObject resolves the config map against a fake API server serving the handler
+func newTestObject(t *testing.T, handler http.HandlerFunc, name string) *boxObject {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	gv := schema.GroupVersion{Version: "v1"}
+	client, err := utils.NewRestClient(rest.Config{Host: server.URL}, gv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping := &meta.RESTMapping{
+		Resource:         gv.WithResource("configmaps"),
+		GroupVersionKind: gv.WithKind("ConfigMap"),
+		Scope:            meta.RESTScopeNamespace,
+	}
+	return &boxObject{
+		obj:        newTestConfigMap(name, "a"),
+		mapping:    mapping,
+		restHelper: resource.NewHelper(client, mapping),
+		namespace:  "default",
+		name:       name,
+	}
+}
+
+func newTestConfigMap(name string, value string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Data:       map[string]string{"key": value},
+	}
+}
+
+// writeTestResponse answers a fake API server request with the object encoded as JSON
+func writeTestResponse(w http.ResponseWriter, code int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(obj)
+}
+
+func newTestStatus(code int32, reason metav1.StatusReason, message string) metav1.Status {
+	return metav1.Status{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+		Status:   metav1.StatusFailure,
+		Code:     code,
+		Reason:   reason,
+		Message:  message,
+	}
+}
+
+func TestSanitizeObject(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  map[string]interface{}
+		want map[string]interface{}
+	}{
+		{
+			name: "server fields are removed",
+			obj: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name":              "web",
+					"uid":               "0b4a3f5e",
+					"resourceVersion":   "42",
+					"generation":        int64(3),
+					"creationTimestamp": "2023-06-01T00:00:00Z",
+					"selfLink":          "/api/v1/namespaces/default/configmaps/web",
+					"managedFields":     []interface{}{map[string]interface{}{"manager": "k8sbox"}},
+				},
+				"data":   map[string]interface{}{"key": "a"},
+				"status": map[string]interface{}{"phase": "Active"},
+			},
+			want: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]interface{}{"name": "web"},
+				"data":       map[string]interface{}{"key": "a"},
+			},
+		},
+		{
+			name: "labels and annotations are kept",
+			obj: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name":        "web",
+					"labels":      map[string]interface{}{"app": "web"},
+					"annotations": map[string]interface{}{"team": "platform"},
+				},
+			},
+			want: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name":        "web",
+					"labels":      map[string]interface{}{"app": "web"},
+					"annotations": map[string]interface{}{"team": "platform"},
+				},
+			},
+		},
+		{
+			name: "revision annotation is removed",
+			obj: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name":        "web",
+					"annotations": map[string]interface{}{structs.ANNOTATION_REVISION: "3", "team": "platform"},
+				},
+			},
+			want: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name":        "web",
+					"annotations": map[string]interface{}{"team": "platform"},
+				},
+			},
+		},
+		{
+			name: "annotations left empty are removed",
+			obj: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name":        "web",
+					"annotations": map[string]interface{}{structs.ANNOTATION_REVISION: "3"},
+				},
+			},
+			want: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]interface{}{"name": "web"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &unstructured.Unstructured{Object: tt.obj}
+			got, err := sanitizeObject(obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sanitizeObject() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsObjectChanged(t *testing.T) {
+	live := newTestConfigMap("web", "a")
+	live.ResourceVersion = "42"
+	live.UID = "0b4a3f5e"
+	live.CreationTimestamp = metav1.Now()
+
+	tests := []struct {
+		name    string
+		desired *corev1.ConfigMap
+		want    bool
+	}{
+		{name: "same data", desired: newTestConfigMap("web", "a"), want: false},
+		{name: "changed data", desired: newTestConfigMap("web", "b"), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := isObjectChanged(live, tt.desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("isObjectChanged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreviewObject(t *testing.T) {
+	live := newTestConfigMap("web", "a")
+	live.ResourceVersion = "42"
+	notFound := newTestStatus(http.StatusNotFound, metav1.StatusReasonNotFound, `configmaps "web" not found`)
+	invalid := newTestStatus(http.StatusUnprocessableEntity, metav1.StatusReasonInvalid, "data is invalid")
+
+	tests := []struct {
+		name            string
+		namespaceExists bool
+		get             interface{}
+		getCode         int
+		dryRun          interface{}
+		dryRunCode      int
+		wantAction      structs.ObjectAction
+		wantReason      string
+	}{
+		{
+			name:            "missing object is created",
+			namespaceExists: true,
+			get:             notFound,
+			getCode:         http.StatusNotFound,
+			dryRun:          newTestConfigMap("web", "a"),
+			dryRunCode:      http.StatusCreated,
+			wantAction:      structs.ACTION_CREATE,
+		},
+		{
+			name:       "missing namespace is validated client-side only",
+			get:        notFound,
+			getCode:    http.StatusNotFound,
+			wantAction: structs.ACTION_CREATE,
+			wantReason: "Namespace default doesn't exist yet, validated client-side only",
+		},
+		{
+			name:            "rejected create",
+			namespaceExists: true,
+			get:             notFound,
+			getCode:         http.StatusNotFound,
+			dryRun:          invalid,
+			dryRunCode:      http.StatusUnprocessableEntity,
+			wantAction:      structs.ACTION_REJECT,
+			wantReason:      "data is invalid",
+		},
+		{
+			name:            "unchanged object",
+			namespaceExists: true,
+			get:             live,
+			getCode:         http.StatusOK,
+			dryRun:          newTestConfigMap("web", "a"),
+			dryRunCode:      http.StatusOK,
+			wantAction:      structs.ACTION_UNCHANGED,
+		},
+		{
+			name:            "changed object",
+			namespaceExists: true,
+			get:             live,
+			getCode:         http.StatusOK,
+			dryRun:          newTestConfigMap("web", "b"),
+			dryRunCode:      http.StatusOK,
+			wantAction:      structs.ACTION_CHANGE,
+		},
+		{
+			name:            "rejected apply",
+			namespaceExists: true,
+			get:             live,
+			getCode:         http.StatusOK,
+			dryRun:          invalid,
+			dryRunCode:      http.StatusUnprocessableEntity,
+			wantAction:      structs.ACTION_REJECT,
+			wantReason:      "data is invalid",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newTestObject(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					writeTestResponse(w, tt.getCode, tt.get)
+					return
+				}
+				if r.URL.Query().Get("dryRun") != "All" {
+					t.Errorf("%s %s is not a dry run", r.Method, r.URL)
+				}
+				writeTestResponse(w, tt.dryRunCode, tt.dryRun)
+			}, "web")
+			action, reason := previewObject(o, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web\ndata:\n  key: b\n", tt.namespaceExists)
+			if action != tt.wantAction || reason != tt.wantReason {
+				t.Errorf("previewObject() = %s, %q, want %s, %q", action, reason, tt.wantAction, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestDiffObjects(t *testing.T) {
+	live := newTestConfigMap("web", "a")
+	live.ResourceVersion = "42"
+	live.UID = "0b4a3f5e"
+
+	tests := []struct {
+		name    string
+		report  structs.ObjectReport
+		live    runtime.Object
+		desired runtime.Object
+		want    []string
+	}{
+		{
+			name:    "changed object",
+			report:  structs.ObjectReport{Kind: "ConfigMap", Namespace: "default", Name: "web"},
+			live:    live,
+			desired: newTestConfigMap("web", "b"),
+			want:    []string{"--- live/ConfigMap/default/web", "+++ k8sbox/ConfigMap/default/web", "-  key: a", "+  key: b", "   name: web"},
+		},
+		{
+			name:    "server fields make no difference",
+			report:  structs.ObjectReport{Kind: "ConfigMap", Namespace: "default", Name: "web"},
+			live:    live,
+			desired: newTestConfigMap("web", "a"),
+		},
+		{
+			name:    "created object",
+			report:  structs.ObjectReport{Kind: "ConfigMap", Namespace: "default", Name: "web"},
+			desired: newTestConfigMap("web", "a"),
+			want:    []string{"--- live/ConfigMap/default/web", "+kind: ConfigMap", "+  key: a", "+  namespace: default"},
+		},
+		{
+			name:   "deleted cluster-scoped object",
+			report: structs.ObjectReport{Kind: "Namespace", Name: "web"},
+			live: &corev1.Namespace{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+				ObjectMeta: metav1.ObjectMeta{Name: "web"},
+				Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceActive},
+			},
+			want: []string{"--- live/Namespace/web", "+++ k8sbox/Namespace/web", "-kind: Namespace", "-  name: web"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := diffObjects(tt.report, tt.live, tt.desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.want) == 0 && len(got) > 0 {
+				t.Errorf("diffObjects() = %q, want no difference", got)
+			}
+			lines := strings.Split(got, "\n")
+			for _, line := range tt.want {
+				if !slices.Contains(lines, line) {
+					t.Errorf("diffObjects() = %q, want the line %q", got, line)
+				}
+			}
+			if strings.Contains(got, "Active") {
+				t.Errorf("diffObjects() = %q, want the status left out", got)
+			}
+		})
+	}
+}
+
+func TestObjectTrackerRollback(t *testing.T) {
+	type tracked struct {
+		box  string
+		name string
+	}
+	tests := []struct {
+		name        string
+		objects     []tracked
+		boxes       []string
+		wantDeleted []string
+		wantKept    []string
+		wantErr     string
+	}{
+		{
+			name:        "every object in the reversed creation order",
+			objects:     []tracked{{"db", "db-config"}, {"api", "api-config"}, {"db", "db-secret"}},
+			wantDeleted: []string{"db-secret", "api-config", "db-config"},
+		},
+		{
+			name:        "only the objects of the given boxes",
+			objects:     []tracked{{"db", "db-config"}, {"api", "api-config"}, {"db", "db-secret"}, {"web", "web-config"}},
+			boxes:       []string{"db", "web"},
+			wantDeleted: []string{"web-config", "db-secret", "db-config"},
+			wantKept:    []string{"api-config"},
+		},
+		{
+			name:        "objects deleted by someone else are skipped",
+			objects:     []tracked{{"db", "db-config"}, {"db", "gone"}},
+			wantDeleted: []string{"gone", "db-config"},
+		},
+		{
+			name:        "failed deletes are reported after the rest is deleted",
+			objects:     []tracked{{"db", "db-config"}, {"db", "locked"}},
+			wantDeleted: []string{"locked", "db-config"},
+			wantErr:     "ConfigMap locked: configmaps \"locked\" is forbidden",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			var deleted []string
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodDelete {
+					t.Errorf("unexpected %s %s", r.Method, r.URL)
+				}
+				name := path.Base(r.URL.Path)
+				mutex.Lock()
+				deleted = append(deleted, name)
+				mutex.Unlock()
+				switch name {
+				case "gone":
+					writeTestResponse(w, http.StatusNotFound, newTestStatus(http.StatusNotFound, metav1.StatusReasonNotFound, fmt.Sprintf("configmaps %q not found", name)))
+				case "locked":
+					writeTestResponse(w, http.StatusForbidden, newTestStatus(http.StatusForbidden, metav1.StatusReasonForbidden, fmt.Sprintf("configmaps %q is forbidden", name)))
+				default:
+					writeTestResponse(w, http.StatusOK, metav1.Status{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"}, Status: metav1.StatusSuccess})
+				}
+			}
+
+			tracker := &objectTracker{}
+			for _, object := range tt.objects {
+				tracker.track(object.box, newTestObject(t, handler, object.name))
+			}
+			var err error
+			if tt.boxes == nil {
+				err = tracker.rollback()
+			} else {
+				err = tracker.rollbackBoxes(tt.boxes)
+			}
+			if len(tt.wantErr) == 0 && err != nil {
+				t.Errorf("rollback() error = %v", err)
+			}
+			if len(tt.wantErr) > 0 && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("rollback() error = %v, want %s", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(deleted, tt.wantDeleted) {
+				t.Errorf("rollback() deleted %v, want %v", deleted, tt.wantDeleted)
+			}
+			var kept []string
+			for _, object := range tracker.objects {
+				kept = append(kept, object.object.name)
+			}
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Errorf("rollback() kept %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
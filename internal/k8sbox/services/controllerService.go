@@ -0,0 +1,153 @@
+// Package services contains buisness-logic methods of the models
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// The lease timings of the controller replicas, the same ones kube-controller-manager uses
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// NewControllerService creates a new ControllerService
+func NewControllerService() structs.ControllerService {
+	return structs.ControllerService{
+		Run: runController,
+	}
+}
+
+// runController reconciles the saved environments of the whole cluster every interval until the context is done
+func runController(ctx context.Context, options structs.ControllerOptions) error {
+	return runLeaderLoop(ctx, options, reconcileEnvironments)
+}
+
+// runLeaderLoop runs the reconcile every interval until the context is done.
+// Only the replica holding the lease reconciles, it finishes the reconcile at hand and releases the lease on shutdown.
+func runLeaderLoop(ctx context.Context, options structs.ControllerOptions, reconcile func(context.Context, func() bool) error) error {
+	var leading atomic.Bool
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Name:      options.LeaseName,
+				Namespace: options.LeaseNamespace,
+			},
+			Client:     k8sclient.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: options.Identity},
+		},
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            options.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				leading.Store(true)
+				log.Printf("%s is the leader now, reconciling every %s", options.Identity, options.Interval)
+			},
+			OnStoppedLeading: func() {
+				if leading.Swap(false) {
+					log.Printf("%s is not the leader anymore", options.Identity)
+				}
+			},
+			OnNewLeader: func(identity string) {
+				if identity != options.Identity {
+					log.Printf("%s is the leader", identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the election outlives the context, so the lease is released only after the last reconcile is over
+	electionCtx, stopElection := context.WithCancel(context.Background())
+	elected := make(chan struct{})
+	go func() {
+		defer close(elected)
+		for electionCtx.Err() == nil {
+			elector.Run(electionCtx)
+		}
+	}()
+
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+	for {
+		if elector.IsLeader() {
+			err := reconcile(ctx, elector.IsLeader)
+			if err != nil {
+				log.Printf("Failed to reconcile: %s", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			stopElection()
+			<-elected
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcileEnvironments reconciles every saved environment of the cluster and records their statuses.
+// Nothing is recorded if the reconcile is interrupted.
+func reconcileEnvironments(ctx context.Context, isLeader func() bool) error {
+	environments, err := getAllSavedEnvironments()
+	if err != nil {
+		return err
+	}
+
+	statuses := make(map[string][]structs.EnvironmentStatus)
+	for i := range environments {
+		if ctx.Err() != nil || !isLeader() {
+			return nil
+		}
+		environment := environments[i]
+		namespaceStatuses := statuses[environment.Namespace]
+		status, err := reconcileEnvironment(&environment)
+		if err != nil {
+			status.Phase = structs.PHASE_FAILED
+			status.Message = err.Error()
+		}
+		if status.Phase != structs.PHASE_HEALTHY {
+			log.Printf("Environment %s (namespace %s) is %s %s", environment.ID, environment.Namespace, status.Phase, formatStatusDetails(status))
+		}
+		if status.Phase != structs.PHASE_EXPIRED {
+			namespaceStatuses = append(namespaceStatuses, status)
+		}
+		statuses[environment.Namespace] = namespaceStatuses
+	}
+
+	for namespace, namespaceStatuses := range statuses {
+		err := saveStatuses(namespace, namespaceStatuses)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatStatusDetails(status structs.EnvironmentStatus) string {
+	switch {
+	case len(status.Message) > 0:
+		return status.Message
+	case len(status.Drifted) > 0:
+		return fmt.Sprintf("(drifted: %s)", strings.Join(status.Drifted, ", "))
+	case len(status.Recreated) > 0:
+		return fmt.Sprintf("(recreated: %s)", strings.Join(status.Recreated, ", "))
+	}
+	return ""
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/utils"
+)
+
+func TestFormatStatusDetails(t *testing.T) {
+	tests := []struct {
+		name   string
+		status structs.EnvironmentStatus
+		want   string
+	}{
+		{
+			name:   "healthy environment",
+			status: structs.EnvironmentStatus{Phase: structs.PHASE_HEALTHY},
+			want:   "",
+		},
+		{
+			name:   "failed environment shows the message",
+			status: structs.EnvironmentStatus{Phase: structs.PHASE_FAILED, Message: "Box api: deadline exceeded", Drifted: []string{"api: Deployment/api"}},
+			want:   "Box api: deadline exceeded",
+		},
+		{
+			name: "drifted objects",
+			status: structs.EnvironmentStatus{
+				Phase:     structs.PHASE_DRIFTED,
+				Drifted:   []string{"api: Deployment/api", "db: Service/db"},
+				Recreated: []string{"db: ConfigMap/db"},
+			},
+			want: "(drifted: api: Deployment/api, db: Service/db)",
+		},
+		{
+			name:   "recreated objects",
+			status: structs.EnvironmentStatus{Phase: structs.PHASE_RECREATED, Recreated: []string{"db: ConfigMap/db"}},
+			want:   "(recreated: db: ConfigMap/db)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatStatusDetails(tt.status); got != tt.want {
+				t.Errorf("formatStatusDetails() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileEnvironmentPhase(t *testing.T) {
+	t.Setenv("K8SBOX_STORAGE_TYPE", string(structs.TYPE_FILESYSTEM))
+	prefix := fmt.Sprintf("reconcile-test-%d", time.Now().UnixNano())
+
+	tests := []struct {
+		name        string
+		revision    int
+		history     []structs.Revision
+		wantPhase   structs.EnvironmentPhase
+		wantMessage string
+	}{
+		{
+			name:      "environment saved before the history",
+			wantPhase: structs.PHASE_HEALTHY,
+		},
+		{
+			name:     "deployed revision",
+			revision: 2,
+			history: []structs.Revision{
+				{Number: 1, Status: structs.REVISION_SUCCEEDED},
+				{Number: 2, Status: structs.REVISION_SUCCEEDED},
+			},
+			wantPhase: structs.PHASE_HEALTHY,
+		},
+		{
+			name:     "revision being deployed",
+			revision: 2,
+			history: []structs.Revision{
+				{Number: 1, Status: structs.REVISION_SUCCEEDED},
+			},
+			wantPhase: structs.PHASE_DEPLOYING,
+		},
+		{
+			name:     "failed revision",
+			revision: 2,
+			history: []structs.Revision{
+				{Number: 1, Status: structs.REVISION_SUCCEEDED},
+				{Number: 2, Status: structs.REVISION_FAILED, Message: "Box api: deadline exceeded"},
+			},
+			wantPhase:   structs.PHASE_FAILED,
+			wantMessage: "Box api: deadline exceeded",
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			environment := structs.Environment{
+				ID:        fmt.Sprintf("%s-%d", prefix, i),
+				Namespace: "default",
+				Revision:  tt.revision,
+				ExpiresAt: time.Now().Add(time.Hour),
+			}
+			t.Cleanup(func() {
+				utils.RemoveRevisions(environment.ID)
+			})
+			for _, revision := range tt.history {
+				revision.Environment = environment
+				err := utils.SaveRevision(revision)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			status, err := reconcileEnvironment(&environment)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := structs.EnvironmentStatus{
+				ID:        environment.ID,
+				Revision:  tt.revision,
+				Phase:     tt.wantPhase,
+				Message:   tt.wantMessage,
+				CheckedAt: status.CheckedAt,
+			}
+			if !reflect.DeepEqual(status, want) {
+				t.Errorf("reconcileEnvironment() = %+v, want %+v", status, want)
+			}
+		})
+	}
+}
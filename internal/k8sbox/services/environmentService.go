@@ -5,16 +5,35 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/utils"
 	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/release"
 	v1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/utils/strings/slices"
+	"sigs.k8s.io/yaml"
 )
 
 // NewEnvironmentService creates a new EnvironmentService
@@ -22,9 +41,19 @@ func NewEnvironmentService() structs.EnvironmentService {
 	return structs.EnvironmentService{
 		DeployEnvironment:          deployEnvironment,
 		DeleteEnvironment:          deleteEnvironment,
+		DeleteEnvironmentCRDs:      deleteEnvironmentCRDs,
 		ValidateEnvironment:        validateEnvironment,
 		ExpandVariables:            expandVariables,
 		PrepareToWorkWithNamespace: prepareToWorkWithNamespace,
+		ConnectToCluster:           connectToCluster,
+		DryRunEnvironment:          dryRunEnvironment,
+		DiffEnvironment:            diffEnvironment,
+		TestEnvironment:            testEnvironment,
+		RollbackEnvironment:        rollbackEnvironment,
+		PruneEnvironment:           pruneEnvironment,
+		GetExpiredEnvironments:     getExpiredEnvironments,
+		ExtendEnvironment:          extendEnvironment,
+		ReconcileEnvironment:       reconcileEnvironment,
 	}
 }
 
@@ -33,24 +62,301 @@ func expandVariables(environment *structs.Environment) {
 	environment.ID = os.ExpandEnv(environment.ID)
 	environment.Namespace = os.ExpandEnv(environment.Namespace)
 	environment.Variables = os.ExpandEnv(environment.Variables)
+	environment.TTL = os.ExpandEnv(environment.TTL)
 }
 
 func deleteEnvironment(environment *structs.Environment) error {
-	for _, box := range environment.Boxes {
-		_, err := uninstallBox(*environment, box)
-		if err != nil {
-			return err
+	// sweep the objects the saved render doesn't know about, e.g. the ones of renamed templates.
+	// It runs while the whole render is still saved, so the rendered objects are left to the boxes.
+	_, err := pruneEnvironment(environment.Namespace, environment.ID, false)
+	if err != nil {
+		return err
+	}
+	saved, err := findSavedEnvironment(environment.Namespace, environment.ID)
+	if err != nil {
+		return err
+	}
+	boxes := make([]structs.Box, len(environment.Boxes))
+	copy(boxes, environment.Boxes)
+	for i := range boxes {
+		if savedBox := findBox(saved, boxes[i].Name); savedBox != nil {
+			// a box rendered from the toml file doesn't know which cluster-scoped objects it has created
+			boxes[i].ClusterObjects = savedBox.ClusterObjects
 		}
 	}
-	err := deleteSavedEnvironment(*environment)
+	env := *environment
+	// boxes are uninstalled only after every box that depends on them
+	err = utils.RunBoxGraph(boxes, structs.DEFAULT_CONCURRENCY, true, func(box *structs.Box) error {
+		_, err := uninstallBox(env, *box)
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	return deleteSavedEnvironment(*environment)
+}
+
+// labelledObject is a cluster object that carries the ownership labels of an environment
+type labelledObject struct {
+	key        objectKey
+	box        string
+	revision   int
+	restHelper *resource.Helper
+}
+
+// pruneEnvironment removes the labelled objects of the environment that are not a part of its saved render.
+// If the environment is not saved anymore, every labelled object is removed. Objects of a revision newer than
+// every recorded one belong to a deploy in progress and are left alone.
+func pruneEnvironment(namespace string, environmentID string, dryRun bool) ([]structs.ObjectReport, error) {
+	environment, err := findSavedEnvironment(namespace, environmentID)
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := getRevisions(namespace, environmentID)
+	if err != nil {
+		return nil, err
+	}
+	lastRevision := 0
+	for _, revision := range revisions {
+		if revision.Number > lastRevision {
+			lastRevision = revision.Number
+		}
+	}
+
+	namespaces := []string{namespace}
+	rendered := make(map[objectKey]bool)
+	reason := "The environment is not saved"
+	if environment != nil {
+		reason = "Not in the environment render"
+		if environment.Revision > lastRevision {
+			lastRevision = environment.Revision
+		}
+		for _, box := range environment.Boxes {
+			if !slices.Contains(namespaces, box.Namespace) {
+				namespaces = append(namespaces, box.Namespace)
+			}
+		}
+		rendered, err = getRenderedObjectKeys(environment)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	objects, err := findLabelledObjects(namespace, environmentID, namespaces)
+	if err != nil {
+		return nil, err
+	}
+	var reports []structs.ObjectReport
+	for _, o := range objects {
+		if rendered[o.key] {
+			continue
+		}
+		if lastRevision > 0 && o.revision > lastRevision {
+			continue
+		}
+		if !dryRun {
+			policy := metav1.DeletePropagationBackground
+			_, err := o.restHelper.DeleteWithOptions(o.key.namespace, o.key.name, &metav1.DeleteOptions{PropagationPolicy: &policy})
+			if err != nil && !k8serrors.IsNotFound(err) {
+				return nil, err
+			}
+		}
+		reports = append(reports, structs.ObjectReport{
+			Box:       o.box,
+			Kind:      o.key.kind,
+			Namespace: o.key.namespace,
+			Name:      o.key.name,
+			Action:    structs.ACTION_DELETE,
+			Reason:    reason,
+		})
+	}
+	return reports, nil
+}
+
+// findLabelledObjects lists the objects of the environment in the namespaces and across every cluster-scoped resource.
+// Objects owned by other objects (e.g. pods of a deployment) are left to the k8s garbage collector,
+// endpoints are skipped as they copy the labels of their services.
+func findLabelledObjects(namespace string, environmentID string, namespaces []string) ([]labelledObject, error) {
+	lists, err := discovery.ServerPreferredResources(clients.discovery)
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, err
+	}
+	lists = discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list", "delete"}}, lists)
+	selector := utils.GetEnvironmentSelector(environmentID, namespace)
+
+	var objects []labelledObject
+	seen := make(map[types.UID]bool)
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, err
+		}
+		client, err := clients.restClient(gv)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range list.APIResources {
+			if strings.Contains(r.Name, "/") || (gv.Group == "" && r.Kind == "Endpoints") {
+				continue
+			}
+			mapping := &meta.RESTMapping{
+				Resource:         gv.WithResource(r.Name),
+				GroupVersionKind: gv.WithKind(r.Kind),
+				Scope:            meta.RESTScopeRoot,
+			}
+			scopes := []string{metav1.NamespaceNone}
+			if r.Namespaced {
+				mapping.Scope = meta.RESTScopeNamespace
+				scopes = namespaces
+			}
+			restHelper := resource.NewHelper(client, mapping)
+			for _, scope := range scopes {
+				result, err := restHelper.List(scope, gv.String(), &metav1.ListOptions{LabelSelector: selector})
+				if k8serrors.IsForbidden(err) || k8serrors.IsNotFound(err) || k8serrors.IsMethodNotSupported(err) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				items, err := meta.ExtractList(result)
+				if err != nil {
+					return nil, err
+				}
+				for _, item := range items {
+					accessor, err := meta.Accessor(item)
+					if err != nil {
+						return nil, err
+					}
+					if len(accessor.GetOwnerReferences()) > 0 || seen[accessor.GetUID()] {
+						continue
+					}
+					seen[accessor.GetUID()] = true
+					revision, _ := strconv.Atoi(accessor.GetAnnotations()[structs.ANNOTATION_REVISION])
+					objects = append(objects, labelledObject{
+						key: objectKey{
+							group:     gv.Group,
+							kind:      r.Kind,
+							namespace: accessor.GetNamespace(),
+							name:      accessor.GetName(),
+						},
+						box:        accessor.GetLabels()[structs.LABEL_BOX],
+						revision:   revision,
+						restHelper: restHelper,
+					})
+				}
+			}
+		}
+	}
+	return objects, nil
+}
+
+// getRenderedObjectKeys returns the keys of every object of the environment render.
+// The keys are added without the namespace as well, as the cluster-scoped objects have none.
+func getRenderedObjectKeys(environment *structs.Environment) (map[objectKey]bool, error) {
+	rendered := make(map[objectKey]bool)
+	for _, box := range environment.Boxes {
+		for _, rend := range utils.ConvertHelmRenderToYaml(box.HelmRender) {
+			key, err := getManifestKey(rend, box.Namespace)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = true
+			key.namespace = ""
+			rendered[key] = true
+		}
+	}
+	return rendered, nil
+}
+
+// getManifestKey identifies the object of the manifest deployed to the namespace
+func getManifestKey(manifest string, namespace string) (objectKey, error) {
+	obj := &unstructured.Unstructured{}
+	data, err := yaml.YAMLToJSON([]byte(manifest))
+	if err != nil {
+		return objectKey{}, err
+	}
+	err = obj.UnmarshalJSON(data)
+	if err != nil {
+		return objectKey{}, err
+	}
+	gvk := obj.GroupVersionKind()
+	return objectKey{group: gvk.Group, kind: gvk.Kind, namespace: namespace, name: obj.GetName()}, nil
+}
+
+// deleteEnvironmentCRDs removes the CRDs installed from the charts crds/ directories.
+// CRDs that are still used by other environments are left alone.
+func deleteEnvironmentCRDs(environment *structs.Environment) error {
+	for _, box := range environment.Boxes {
+		err := uninstallBoxCRDs(*environment, box)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 var k8sclient *kubernetes.Clientset
 var restConfig *rest.Config
+var clients *clientBundle
+
+// clientBundle caches the API discovery and the REST clients for the whole k8sbox run.
+// The discovery is fetched again only when a kind can't be mapped, e.g. after a CRD install.
+type clientBundle struct {
+	discovery   discovery.CachedDiscoveryInterface
+	mapper      *restmapper.DeferredDiscoveryRESTMapper
+	mutex       sync.Mutex
+	restClients map[schema.GroupVersion]rest.Interface
+}
+
+func newClientBundle(k8sclient *kubernetes.Clientset) *clientBundle {
+	cachedDiscovery := memory.NewMemCacheClient(k8sclient.Discovery())
+	return &clientBundle{
+		discovery:   cachedDiscovery,
+		mapper:      restmapper.NewDeferredDiscoveryRESTMapper(cachedDiscovery),
+		restClients: make(map[schema.GroupVersion]rest.Interface),
+	}
+}
+
+// restMapping maps the object kind to its API resource
+func (c *clientBundle) restMapping(obj runtime.Object) (*meta.RESTMapping, error) {
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	gk := schema.GroupKind{Group: gvk.Group, Kind: gvk.Kind}
+	mapping, err := c.mapper.RESTMapping(gk, gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// the kind may have been added to the cluster after the discovery was cached
+		c.mapper.Reset()
+		mapping, err = c.mapper.RESTMapping(gk, gvk.Version)
+	}
+	return mapping, err
+}
+
+// restClient returns the REST client of the group version, creating it on the first use
+func (c *clientBundle) restClient(gv schema.GroupVersion) (rest.Interface, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if client, ok := c.restClients[gv]; ok {
+		return client, nil
+	}
+	client, err := utils.NewRestClient(*restConfig, gv)
+	if err != nil {
+		return nil, err
+	}
+	c.restClients[gv] = client
+	return client, nil
+}
+
+// restHelper returns the mapping of the object together with a REST helper to work with it
+func (c *clientBundle) restHelper(obj runtime.Object) (*meta.RESTMapping, *resource.Helper, error) {
+	mapping, err := c.restMapping(obj)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := c.restClient(mapping.GroupVersionKind.GroupVersion())
+	if err != nil {
+		return nil, nil, err
+	}
+	return mapping, resource.NewHelper(client, mapping), nil
+}
 
 // GetConfigFromKubeconfig is loading your Kubeconfig into configuration struct
 func GetConfigFromKubeconfig(namespace string) *rest.Config {
@@ -64,13 +370,23 @@ func GetConfigFromKubeconfig(namespace string) *rest.Config {
 }
 
 func prepareToWorkWithNamespace(namespace string) error {
+	err := connectToCluster(namespace)
+	if err != nil {
+		return err
+	}
+	return createNamespaceIfNotExists(namespace)
+}
+
+// connectToCluster creates the k8s clients without changing anything in the cluster
+func connectToCluster(namespace string) error {
 	restConfig = GetConfigFromKubeconfig(namespace)
 	cl, err := kubernetes.NewForConfig(restConfig)
-	k8sclient = cl
 	if err != nil {
 		return err
 	}
-	return createNamespaceIfNotExists(namespace)
+	k8sclient = cl
+	clients = newClientBundle(cl)
+	return nil
 }
 
 func createNamespaceIfNotExists(namespace string) error {
@@ -89,15 +405,593 @@ func createNamespaceIfNotExists(namespace string) error {
 	return err
 }
 
-func deployEnvironment(environment *structs.Environment) error {
-	saveEnvironment(*environment)
+// deployEnvironment deploys the environment as its next revision and records the outcome in the environment history
+func deployEnvironment(environment *structs.Environment, options structs.DeployOptions) error {
+	revision, err := getNextRevisionNumber(environment.Namespace, environment.ID)
+	if err != nil {
+		return err
+	}
+	environment.Revision = revision
+	previous, err := findSavedEnvironment(environment.Namespace, environment.ID)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		// the environment being recreated was deleted before the deploy
+		previous = options.Previous
+	}
+	err = setEnvironmentTimestamps(environment, previous)
+	if err != nil {
+		return err
+	}
+
+	if options.Apply {
+		err = applyEnvironment(environment, options, upgradeHooks)
+		return recordRevision(*environment, "Apply", err)
+	}
+	err = installEnvironment(environment, options)
+	return recordRevision(*environment, "Install", err)
+}
+
+// rollbackEnvironment applies the render of an earlier revision as the next revision of the environment.
+// Revision 0 stands for the last succeeded revision before the current one.
+func rollbackEnvironment(namespace string, id string, revision int, options structs.DeployOptions) (*structs.Environment, error) {
+	revisions, err := getRevisions(namespace, id)
+	if err != nil {
+		return nil, err
+	}
+	current, err := findSavedEnvironment(namespace, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var target *structs.Revision
+	for i := len(revisions) - 1; i >= 0; i-- {
+		r := revisions[i]
+		if revision != 0 && r.Number == revision {
+			target = &r
+			break
+		}
+		if revision == 0 && r.Status == structs.REVISION_SUCCEEDED && (current == nil || r.Number < current.Revision) {
+			target = &r
+			break
+		}
+	}
+	if target == nil {
+		if revision == 0 {
+			return nil, fmt.Errorf("Environment %s has no succeeded revision to roll back to", id)
+		}
+		return nil, fmt.Errorf("Revision %d of environment %s is not found in the history", revision, id)
+	}
+
+	environment := target.Environment
+	environment.Revision = revisions[len(revisions)-1].Number + 1
+	err = setEnvironmentTimestamps(&environment, current)
+	if err != nil {
+		return nil, err
+	}
+	err = applyEnvironment(&environment, options, rollbackHooks)
+	return &environment, recordRevision(environment, fmt.Sprintf("Rollback to %d", target.Number), err)
+}
+
+// redeployEnvironment applies the render of the saved environment once again as its next revision
+func redeployEnvironment(namespace string, id string, options structs.DeployOptions) (*structs.Environment, error) {
+	current, err := findSavedEnvironment(namespace, id)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, fmt.Errorf("Environment %s not found in namespace %s", id, namespace)
+	}
+	environment := *current
+	environment.Revision, err = getNextRevisionNumber(namespace, id)
+	if err != nil {
+		return nil, err
+	}
+	err = setEnvironmentTimestamps(&environment, current)
+	if err != nil {
+		return nil, err
+	}
+	err = applyEnvironment(&environment, options, upgradeHooks)
+	return &environment, recordRevision(environment, "Redeploy", err)
+}
+
+// setEnvironmentTimestamps records when the environment was created and updated, and when its TTL runs out.
+// A deploy never brings an extended expiry closer.
+func setEnvironmentTimestamps(environment *structs.Environment, previous *structs.Environment) error {
+	now := time.Now()
+	environment.CreatedAt = now
+	if previous != nil && !previous.CreatedAt.IsZero() {
+		environment.CreatedAt = previous.CreatedAt
+	}
+	environment.UpdatedAt = now
+
+	ttl, err := environment.GetTTL()
+	if err != nil {
+		return err
+	}
+	environment.ExpiresAt = time.Time{}
+	if ttl > 0 {
+		environment.ExpiresAt = now.Add(ttl)
+		if previous != nil && previous.ExpiresAt.After(environment.ExpiresAt) {
+			environment.ExpiresAt = previous.ExpiresAt
+		}
+	}
+	return nil
+}
+
+// getExpiredEnvironments returns the saved environments that have outlived their TTL, the longest expired go first.
+// An empty namespace stands for every namespace.
+func getExpiredEnvironments(namespace string) ([]structs.Environment, error) {
+	environments, err := getAllSavedEnvironments()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var expired []structs.Environment
+	for _, environment := range environments {
+		if len(namespace) > 0 && environment.Namespace != namespace {
+			continue
+		}
+		if environment.IsExpired(now) {
+			expired = append(expired, environment)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].ExpiresAt.Before(expired[j].ExpiresAt)
+	})
+	return expired, nil
+}
+
+// extendEnvironment pushes the expiry of the saved environment back. An expired environment is extended from now on.
+func extendEnvironment(namespace string, id string, duration time.Duration) (*structs.Environment, error) {
+	environment, err := findSavedEnvironment(namespace, id)
+	if err != nil {
+		return nil, err
+	}
+	if environment == nil {
+		return nil, fmt.Errorf("No environment found.")
+	}
+	expiresAt := environment.ExpiresAt
+	if now := time.Now(); expiresAt.Before(now) {
+		expiresAt = now
+	}
+	environment.ExpiresAt = expiresAt.Add(duration)
+	return environment, saveEnvironment(*environment)
+}
+
+// reconcileEnvironment brings the cluster back to the saved environment: an expired environment is deleted,
+// deleted objects are created again and changed ones are reported as drifted.
+// Environments which are being deployed or whose last deploy failed are only observed.
+func reconcileEnvironment(environment *structs.Environment) (structs.EnvironmentStatus, error) {
+	status := structs.EnvironmentStatus{
+		ID:        environment.ID,
+		Revision:  environment.Revision,
+		Phase:     structs.PHASE_HEALTHY,
+		CheckedAt: time.Now(),
+	}
+	if environment.IsExpired(status.CheckedAt) {
+		err := deleteEnvironment(environment)
+		if err != nil {
+			return status, err
+		}
+		status.Phase = structs.PHASE_EXPIRED
+		return status, deleteRevisions(environment.Namespace, environment.ID)
+	}
+
+	deployed, err := findDeployedRevision(*environment)
+	if err != nil {
+		return status, err
+	}
+	if deployed == nil {
+		status.Phase = structs.PHASE_DEPLOYING
+		return status, nil
+	}
+	if deployed.Status == structs.REVISION_FAILED {
+		status.Phase = structs.PHASE_FAILED
+		status.Message = deployed.Message
+		return status, nil
+	}
+
 	for _, box := range environment.Boxes {
-		_, err := installBox(&box, *environment)
+		recreated, err := recreateMissingObjects(box, *environment)
+		if err != nil {
+			return status, err
+		}
+		for _, name := range recreated {
+			status.Recreated = append(status.Recreated, fmt.Sprintf("%s: %s", box.Name, name))
+		}
+
+		reports, err := diffBox(box, nil)
+		if err != nil {
+			return status, err
+		}
+		for _, report := range reports {
+			switch report.Action {
+			case structs.ACTION_CHANGE:
+				status.Drifted = append(status.Drifted, fmt.Sprintf("%s: %s/%s", box.Name, report.Kind, report.Name))
+			case structs.ACTION_REJECT:
+				return status, fmt.Errorf("%s %s can't be compared with the cluster: %s", report.Kind, report.Name, report.Reason)
+			}
+		}
+	}
+	if len(status.Recreated) > 0 {
+		status.Phase = structs.PHASE_RECREATED
+	}
+	if len(status.Drifted) > 0 {
+		status.Phase = structs.PHASE_DRIFTED
+	}
+	return status, nil
+}
+
+// findDeployedRevision returns the history record of the saved environment revision.
+// It returns nil while the revision is being deployed, environments saved before the history existed count as deployed.
+func findDeployedRevision(environment structs.Environment) (*structs.Revision, error) {
+	if environment.Revision == 0 {
+		return &structs.Revision{Environment: environment, Status: structs.REVISION_SUCCEEDED}, nil
+	}
+	revisions, err := getRevisions(environment.Namespace, environment.ID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range revisions {
+		if revisions[i].Number == environment.Revision {
+			return &revisions[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// recordRevision keeps the outcome of the deploy in the environment history. The deploy error is returned as is.
+func recordRevision(environment structs.Environment, description string, deployErr error) error {
+	revision := structs.Revision{
+		Number:      environment.Revision,
+		Environment: environment,
+		Timestamp:   time.Now(),
+		Status:      structs.REVISION_SUCCEEDED,
+		Description: description,
+		Invoker:     utils.GetInvoker(),
+	}
+	if deployErr != nil {
+		revision.Status = structs.REVISION_FAILED
+		revision.Message = deployErr.Error()
+	}
+	err := saveRevision(revision)
+	if err != nil {
+		// the deploy itself is done, a missing history entry must not turn it into a failure
+		log.Printf("Warning: revision %d of environment %s can't be added to the history: %s", revision.Number, environment.ID, err)
+	}
+	return deployErr
+}
+
+// installEnvironment installs the environment from scratch.
+// An atomic install replaces the deployed environment itself: the previous environment stays saved until the new one
+// is installed and is installed again if the deploy fails.
+func installEnvironment(environment *structs.Environment, options structs.DeployOptions) error {
+	var previous *structs.Environment
+	if options.Atomic {
+		var err error
+		previous, err = findSavedEnvironment(environment.Namespace, environment.ID)
+		if err != nil {
+			return err
+		}
+	} else {
+		// the environment is saved before the install, so it can be deleted even if the install breaks halfway
+		err := saveEnvironment(*environment)
+		if err != nil {
+			return err
+		}
+	}
+
+	var tracker *objectTracker
+	if options.Atomic {
+		tracker = &objectTracker{}
+	}
+	env := *environment
+	deadline := getDeployDeadline(options)
+	var err error
+	if previous != nil {
+		err = uninstallEnvironmentObjects(previous, options)
+	}
+	if err == nil {
+		err = utils.RunBoxGraph(environment.Boxes, options.Concurrency, false, func(box *structs.Box) error {
+			_, err := installBox(box, env, tracker, deadline)
+			if err != nil {
+				return err
+			}
+			return waitForDependencyBox(env, *box, options, deadline)
+		})
+	}
+	if err == nil && options.Wait {
+		err = waitForBoxes(environment.Boxes, time.Until(deadline), options.Progress)
+	}
+	if err != nil {
+		if options.Atomic {
+			return rollbackDeploy(environment, options, err, func() error {
+				return reinstallEnvironment(previous, options, tracker)
+			})
+		}
+		// keep track of the cluster-scoped objects that were created before the failure
+		saveErr := saveEnvironment(*environment)
+		if saveErr != nil {
+			return fmt.Errorf("%s\n\rThe environment can't be saved, the objects installed so far have to be deleted by hand:\n\r%s", err, saveErr)
+		}
+		return err
+	}
+
+	return saveEnvironment(*environment)
+}
+
+// uninstallEnvironmentObjects removes the objects of the saved environment, the environment stays saved
+func uninstallEnvironmentObjects(environment *structs.Environment, options structs.DeployOptions) error {
+	_, err := pruneEnvironment(environment.Namespace, environment.ID, false)
+	if err != nil {
+		return err
+	}
+	env := *environment
+	return utils.RunBoxGraph(environment.Boxes, options.Concurrency, true, func(box *structs.Box) error {
+		_, err := uninstallBoxObjects(env, *box, nil)
+		return err
+	})
+}
+
+// reinstallEnvironment brings the previous environment back after a failed atomic install.
+// The objects of the failed install are removed first, then the previous render is installed again.
+func reinstallEnvironment(previous *structs.Environment, options structs.DeployOptions, tracker *objectTracker) error {
+	err := tracker.rollback()
+	if err != nil || previous == nil {
+		return err
+	}
+	prev := *previous
+	err = utils.RunBoxGraph(previous.Boxes, options.Concurrency, false, func(box *structs.Box) error {
+		// the cluster-scoped objects the box creates again are recorded anew
+		box.ClusterObjects = nil
+		// the reinstall isn't bound by the deadline of the failed install
+		_, err := installBox(box, prev, nil, time.Time{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return saveEnvironment(*previous)
+}
+
+// applyEnvironment updates the environment in place with server-side apply.
+// The environment is saved only when every box is applied, so a failed apply can be retried against the previous state.
+func applyEnvironment(environment *structs.Environment, options structs.DeployOptions, hooks applyHooks) error {
+	var previous *structs.Environment
+	saved, err := isEnvironmentSaved(*environment)
+	if err != nil {
+		return err
+	}
+	if saved {
+		previous, err = getSavedEnvironment(environment.Namespace, environment.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	var tracker *objectTracker
+	if options.Atomic {
+		tracker = &objectTracker{}
+	}
+	err = applyEnvironmentBoxes(environment, previous, options, tracker, hooks)
+	if err != nil {
+		if options.Atomic {
+			return rollbackDeploy(environment, options, err, func() error {
+				return restoreEnvironment(environment, previous, options, tracker)
+			})
+		}
+		return err
+	}
+
+	return saveEnvironment(*environment)
+}
+
+func applyEnvironmentBoxes(environment *structs.Environment, previous *structs.Environment, options structs.DeployOptions, tracker *objectTracker, hooks applyHooks) error {
+	env := *environment
+	deadline := getDeployDeadline(options)
+	err := utils.RunBoxGraph(environment.Boxes, options.Concurrency, false, func(box *structs.Box) error {
+		_, err := applyBox(box, env, findBox(previous, box.Name), tracker, hooks, deadline)
+		if err != nil {
+			return err
+		}
+		return waitForDependencyBox(env, *box, options, deadline)
+	})
+	if err != nil {
+		return err
+	}
+
+	if previous != nil {
+		// the previous objects are pruned only when every box is applied, as an object may have moved to another box
+		rendered, err := getRenderedObjectKeys(environment)
+		if err != nil {
+			return err
+		}
+		var removedBoxes []structs.Box
+		for _, box := range previous.Boxes {
+			if findBox(environment, box.Name) == nil {
+				// the box was removed from the specification
+				removedBoxes = append(removedBoxes, box)
+				continue
+			}
+			_, err := deleteBoxObjects(*previous, box, rendered)
+			if err != nil {
+				return err
+			}
+		}
+		err = utils.RunBoxGraph(removedBoxes, options.Concurrency, true, func(box *structs.Box) error {
+			_, err := uninstallBoxExcept(*previous, *box, rendered)
+			return err
+		})
 		if err != nil {
 			return err
 		}
 	}
 
+	if options.Wait {
+		return waitForBoxes(environment.Boxes, time.Until(deadline), options.Progress)
+	}
+	return nil
+}
+
+// restoreEnvironment brings the previous environment back after a failed atomic apply.
+// The boxes that are new to the environment are removed, the rest are applied with their previous render.
+func restoreEnvironment(environment *structs.Environment, previous *structs.Environment, options structs.DeployOptions, tracker *objectTracker) error {
+	if previous == nil {
+		return tracker.rollback()
+	}
+	var newBoxes []string
+	for _, box := range environment.Boxes {
+		if findBox(previous, box.Name) == nil {
+			newBoxes = append(newBoxes, box.Name)
+		}
+	}
+	err := tracker.rollbackBoxes(newBoxes)
+	if err != nil {
+		return err
+	}
+
+	prev := *previous
+	err = utils.RunBoxGraph(previous.Boxes, options.Concurrency, false, func(box *structs.Box) error {
+		// the restore isn't bound by the deadline of the failed apply
+		_, err := applyBox(box, prev, findBox(environment, box.Name), nil, rollbackHooks, time.Time{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// the objects the failed apply added to the previous boxes are pruned
+	rendered, err := getRenderedObjectKeys(previous)
+	if err != nil {
+		return err
+	}
+	for _, box := range environment.Boxes {
+		if findBox(previous, box.Name) == nil {
+			continue
+		}
+		_, err := deleteBoxObjects(*environment, box, rendered)
+		if err != nil {
+			return err
+		}
+	}
+	// the boxes removed from the specification were removed from the saved environment as well
+	return saveEnvironment(*previous)
+}
+
+// rollbackDeploy rolls back the failed atomic deploy. The diagnosis is collected first, as the rollback removes the unhealthy objects.
+func rollbackDeploy(environment *structs.Environment, options structs.DeployOptions, cause error, rollback func() error) error {
+	messages := []string{cause.Error()}
+	if options.Wait {
+		for _, box := range environment.Boxes {
+			diagnosis, err := diagnoseBox(box)
+			if err == nil && !diagnosis.IsHealthy() {
+				messages = append(messages, utils.FormatBoxDiagnosis(diagnosis))
+			}
+		}
+	}
+
+	err := rollback()
+	if err != nil {
+		messages = append(messages, fmt.Sprintf("The rollback failed as well:\n\r%s", err))
+	} else {
+		messages = append(messages, "Every change made by the deploy was rolled back.")
+	}
+	return errors.New(strings.Join(messages, "\n\r"))
+}
+
+// waitForDependencyBox makes the boxes that depend on the box start only when it is ready, with or without --wait
+func waitForDependencyBox(environment structs.Environment, box structs.Box, options structs.DeployOptions, deadline time.Time) error {
+	if !hasDependents(environment.Boxes, box.Name) {
+		return nil
+	}
+	return waitForBoxes([]structs.Box{box}, time.Until(deadline), nil)
+}
+
+// getDeployDeadline returns the time the environment has to become ready by, the default timeout is used if none is set
+func getDeployDeadline(options structs.DeployOptions) time.Time {
+	if options.Timeout <= 0 {
+		return time.Now().Add(structs.DEFAULT_WAIT_TIMEOUT)
+	}
+	return time.Now().Add(options.Timeout)
+}
+
+func hasDependents(boxes []structs.Box, name string) bool {
+	for _, box := range boxes {
+		for _, dependency := range box.DependsOn {
+			if dependency == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// testEnvironment runs the test hooks of every box
+func testEnvironment(environment *structs.Environment) error {
+	for _, box := range environment.Boxes {
+		err := runBoxHooks(box, release.HookTest, time.Time{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dryRunEnvironment reports what deploying the environment would do. Nothing is persisted.
+func dryRunEnvironment(environment *structs.Environment, strategy structs.DryRunStrategy) ([]structs.ObjectReport, error) {
+	var reports []structs.ObjectReport
+	for _, box := range environment.Boxes {
+		boxReports, err := dryRunBox(box, strategy)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, boxReports...)
+	}
+	return reports, nil
+}
+
+// diffEnvironment compares the environment with the live objects and the saved environment state
+func diffEnvironment(environment *structs.Environment) (structs.EnvironmentDiff, error) {
+	var diff structs.EnvironmentDiff
+	previous, err := findSavedEnvironment(environment.Namespace, environment.ID)
+	if err != nil {
+		return diff, err
+	}
+
+	for _, box := range environment.Boxes {
+		boxDiff, err := diffBox(box, findBox(previous, box.Name))
+		if err != nil {
+			return diff, err
+		}
+		diff.Objects = append(diff.Objects, boxDiff...)
+	}
+
+	if previous != nil {
+		for _, box := range previous.Boxes {
+			if findBox(environment, box.Name) != nil {
+				continue
+			}
+			diff.RemovedBoxes = append(diff.RemovedBoxes, box.Name)
+			removed, err := diffRemovedObjects(box, nil)
+			if err != nil {
+				return diff, err
+			}
+			diff.Objects = append(diff.Objects, removed...)
+		}
+	}
+	return diff, nil
+}
+
+func findBox(environment *structs.Environment, name string) *structs.Box {
+	if environment == nil {
+		return nil
+	}
+	for i := range environment.Boxes {
+		if environment.Boxes[i].Name == name {
+			return &environment.Boxes[i]
+		}
+	}
 	return nil
 }
 
@@ -105,12 +999,18 @@ func validateEnvironment(environment *structs.Environment) error {
 	var messages []string
 	if len(strings.TrimSpace(environment.ID)) == 0 {
 		messages = append(messages, "Environment id is missing")
+	} else if errs := validation.IsValidLabelValue(environment.ID); len(errs) > 0 {
+		messages = append(messages, fmt.Sprintf("Environment id can't be used as a label value (%s)", strings.Join(errs, "; ")))
 	}
 
 	if len(strings.TrimSpace(environment.Name)) == 0 {
 		messages = append(messages, "Environment name is missing")
 	}
 
+	if ttl, err := environment.GetTTL(); err != nil || ttl < 0 {
+		messages = append(messages, fmt.Sprintf("Environment ttl is not a valid positive duration (%s)", environment.TTL))
+	}
+
 	if len(strings.TrimSpace(environment.Variables)) > 0 {
 		_, err := os.Stat(environment.Variables)
 		if err != nil {
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestSetEnvironmentTimestamps(t *testing.T) {
+	createdAt := time.Now().Add(-48 * time.Hour)
+	extendedAt := time.Now().Add(72 * time.Hour)
+	expiredAt := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name          string
+		ttl           string
+		previous      *structs.Environment
+		wantCreatedAt time.Time
+		wantExpiresAt time.Time
+		wantTTLExpiry bool
+	}{
+		{
+			name:          "new environment without ttl",
+			wantExpiresAt: time.Time{},
+		},
+		{
+			name:          "new environment with ttl",
+			ttl:           "1h",
+			wantTTLExpiry: true,
+		},
+		{
+			name:          "extended expiry is kept",
+			ttl:           "1h",
+			previous:      &structs.Environment{CreatedAt: createdAt, ExpiresAt: extendedAt},
+			wantCreatedAt: createdAt,
+			wantExpiresAt: extendedAt,
+		},
+		{
+			name:          "expired environment gets a new expiry",
+			ttl:           "1h",
+			previous:      &structs.Environment{CreatedAt: createdAt, ExpiresAt: expiredAt},
+			wantCreatedAt: createdAt,
+			wantTTLExpiry: true,
+		},
+		{
+			name:          "removed ttl clears the expiry",
+			previous:      &structs.Environment{CreatedAt: createdAt, ExpiresAt: extendedAt},
+			wantCreatedAt: createdAt,
+			wantExpiresAt: time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			environment := structs.Environment{TTL: tt.ttl}
+			before := time.Now()
+			err := setEnvironmentTimestamps(&environment, tt.previous)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantCreatedAt.IsZero() && !environment.CreatedAt.Equal(tt.wantCreatedAt) {
+				t.Errorf("CreatedAt = %s, want %s", environment.CreatedAt, tt.wantCreatedAt)
+			}
+			if tt.wantCreatedAt.IsZero() && environment.CreatedAt.Before(before) {
+				t.Errorf("CreatedAt = %s, want the deploy time", environment.CreatedAt)
+			}
+			if tt.wantTTLExpiry {
+				if !environment.ExpiresAt.Equal(environment.UpdatedAt.Add(time.Hour)) {
+					t.Errorf("ExpiresAt = %s, want an hour after %s", environment.ExpiresAt, environment.UpdatedAt)
+				}
+				return
+			}
+			if !environment.ExpiresAt.Equal(tt.wantExpiresAt) {
+				t.Errorf("ExpiresAt = %s, want %s", environment.ExpiresAt, tt.wantExpiresAt)
+			}
+		})
+	}
+}
+
+func TestDeployEnvironmentKeepsExtendedExpiry(t *testing.T) {
+	t.Setenv("K8SBOX_STORAGE_TYPE", string(structs.TYPE_FILESYSTEM))
+	id := fmt.Sprintf("ttl-test-%d", time.Now().UnixNano())
+	namespace := "default"
+	t.Cleanup(func() {
+		deleteSavedEnvironment(structs.Environment{ID: id, Namespace: namespace})
+		deleteRevisions(namespace, id)
+	})
+
+	err := deployEnvironment(&structs.Environment{ID: id, Namespace: namespace, TTL: "1h"}, structs.DeployOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	extended, err := extendEnvironment(namespace, id, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// run without --apply deletes the saved environment before the deploy
+	previous, err := findSavedEnvironment(namespace, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deleteSavedEnvironment(*previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = deployEnvironment(&structs.Environment{ID: id, Namespace: namespace, TTL: "1h"}, structs.DeployOptions{Previous: previous})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := findSavedEnvironment(namespace, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved == nil {
+		t.Fatal("the environment is not saved")
+	}
+	if !saved.ExpiresAt.Equal(extended.ExpiresAt) {
+		t.Errorf("ExpiresAt = %s, want the extended %s", saved.ExpiresAt, extended.ExpiresAt)
+	}
+	if !saved.CreatedAt.Equal(extended.CreatedAt) {
+		t.Errorf("CreatedAt = %s, want %s", saved.CreatedAt, extended.CreatedAt)
+	}
+	if saved.Revision != 2 {
+		t.Errorf("Revision = %d, want 2", saved.Revision)
+	}
+}
+
+func TestClientBundleRestMapping(t *testing.T) {
+	var mutex sync.Mutex
+	requests := make(map[string]int)
+	crdInstalled := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests[r.URL.Path]++
+		switch r.URL.Path {
+		case "/api":
+			writeTestResponse(w, http.StatusOK, metav1.APIVersions{
+				TypeMeta: metav1.TypeMeta{Kind: "APIVersions"},
+				Versions: []string{"v1"},
+			})
+		case "/apis":
+			groups := metav1.APIGroupList{TypeMeta: metav1.TypeMeta{Kind: "APIGroupList", APIVersion: "v1"}, Groups: []metav1.APIGroup{}}
+			if crdInstalled {
+				version := metav1.GroupVersionForDiscovery{GroupVersion: "example.com/v1", Version: "v1"}
+				groups.Groups = append(groups.Groups, metav1.APIGroup{Name: "example.com", Versions: []metav1.GroupVersionForDiscovery{version}, PreferredVersion: version})
+			}
+			writeTestResponse(w, http.StatusOK, groups)
+		case "/api/v1":
+			writeTestResponse(w, http.StatusOK, metav1.APIResourceList{
+				TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{{Name: "configmaps", Namespaced: true, Kind: "ConfigMap", Verbs: []string{"get", "list"}}},
+			})
+		case "/apis/example.com/v1":
+			writeTestResponse(w, http.StatusOK, metav1.APIResourceList{
+				TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+				GroupVersion: "example.com/v1",
+				APIResources: []metav1.APIResource{{Name: "widgets", Namespaced: true, Kind: "Widget", Verbs: []string{"get", "list"}}},
+			})
+		default:
+			writeTestResponse(w, http.StatusNotFound, newTestStatus(http.StatusNotFound, metav1.StatusReasonNotFound, "not found"))
+		}
+	}))
+	defer server.Close()
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := newClientBundle(client)
+	configMap := newTestConfigMap("web", "a")
+	widget := &unstructured.Unstructured{}
+	widget.SetGroupVersionKind(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"})
+
+	for i := 0; i < 2; i++ {
+		mapping, err := bundle.restMapping(configMap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mapping.Resource.Resource != "configmaps" {
+			t.Errorf("restMapping() resource = %s, want configmaps", mapping.Resource.Resource)
+		}
+	}
+	mutex.Lock()
+	fetched := requests["/api/v1"]
+	mutex.Unlock()
+	if fetched != 1 {
+		t.Errorf("the discovery was fetched %d times, want it cached", fetched)
+	}
+
+	_, err = bundle.restMapping(widget)
+	if !meta.IsNoMatchError(err) {
+		t.Fatalf("restMapping() error = %v, want no match before the CRD install", err)
+	}
+	mutex.Lock()
+	crdInstalled = true
+	mutex.Unlock()
+	mapping, err := bundle.restMapping(widget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping.Resource.Resource != "widgets" {
+		t.Errorf("restMapping() resource = %s, want widgets", mapping.Resource.Resource)
+	}
+}
+
+func TestClientBundleRestClient(t *testing.T) {
+	previous := restConfig
+	restConfig = &rest.Config{Host: "https://127.0.0.1:6443"}
+	t.Cleanup(func() {
+		restConfig = previous
+	})
+	bundle := &clientBundle{restClients: make(map[schema.GroupVersion]rest.Interface)}
+
+	core, err := bundle.restClient(schema.GroupVersion{Version: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := bundle.restClient(schema.GroupVersion{Version: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps, err := bundle.restClient(schema.GroupVersion{Group: "apps", Version: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if core != cached {
+		t.Error("restClient() created a new client for the same group version")
+	}
+	if core == apps {
+		t.Error("restClient() shares the client between group versions")
+	}
+	if got := apps.Get().URL().Path; got != "/apis/apps/v1" {
+		t.Errorf("restClient() apps path = %s, want /apis/apps/v1", got)
+	}
+}
+
+func TestGetManifestKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		manifest  string
+		namespace string
+		want      objectKey
+		wantErr   bool
+	}{
+		{
+			name:      "core object",
+			manifest:  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web\n",
+			namespace: "default",
+			want:      objectKey{kind: "ConfigMap", namespace: "default", name: "web"},
+		},
+		{
+			name:      "grouped object",
+			manifest:  "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n",
+			namespace: "default",
+			want:      objectKey{group: "apps", kind: "Deployment", namespace: "default", name: "web"},
+		},
+		{
+			name:      "manifest namespace is ignored",
+			manifest:  "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\n  namespace: other\n",
+			namespace: "default",
+			want:      objectKey{kind: "Service", namespace: "default", name: "web"},
+		},
+		{
+			name:      "invalid manifest",
+			manifest:  "kind: [ConfigMap\n",
+			namespace: "default",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getManifestKey(tt.manifest, tt.namespace)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getManifestKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getManifestKey() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRenderedObjectKeys(t *testing.T) {
+	environment := &structs.Environment{Boxes: []structs.Box{
+		{
+			Name:       "db",
+			Namespace:  "default",
+			HelmRender: map[string]string{"db/templates/service.yaml": "apiVersion: v1\nkind: Service\nmetadata:\n  name: db\n"},
+		},
+		{
+			Name:       "api",
+			Namespace:  "api",
+			HelmRender: map[string]string{"api/templates/role.yaml": "apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata:\n  name: api\n"},
+		},
+	}}
+	got, err := getRenderedObjectKeys(environment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[objectKey]bool{
+		{kind: "Service", namespace: "default", name: "db"}:                                      true,
+		{kind: "Service", name: "db"}:                                                            true,
+		{group: "rbac.authorization.k8s.io", kind: "ClusterRole", namespace: "api", name: "api"}: true,
+		{group: "rbac.authorization.k8s.io", kind: "ClusterRole", name: "api"}:                   true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getRenderedObjectKeys() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,488 @@
+// Package services contains buisness-logic methods of the models
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"helm.sh/helm/v3/pkg/chartutil"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/strings/slices"
+)
+
+// sourceDownloadTimeout limits the time spent downloading a chart or a manifest
+const sourceDownloadTimeout = time.Minute
+
+// maxSourceSize limits the size of a downloaded chart or manifest
+const maxSourceSize = 32 << 20
+
+// failedRetryInterval is the time the operator waits before it deploys a failed environment again
+const failedRetryInterval = 5 * time.Minute
+
+// conditionMessageLength limits the length of the condition messages, the API server rejects longer ones
+const conditionMessageLength = 32768
+
+var environmentResource = schema.GroupVersionResource{
+	Group:    structs.RESOURCE_GROUP,
+	Version:  structs.RESOURCE_VERSION,
+	Resource: structs.RESOURCE_PLURAL,
+}
+
+// NewOperatorService creates a new OperatorService
+func NewOperatorService() structs.OperatorService {
+	return structs.OperatorService{
+		Run: runOperator,
+	}
+}
+
+// runOperator reconciles the Environment custom resources of the whole cluster every interval until the context is done
+func runOperator(ctx context.Context, options structs.ControllerOptions) error {
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	return runLeaderLoop(ctx, options, func(ctx context.Context, isLeader func() bool) error {
+		return reconcileResources(ctx, client, options, isLeader)
+	})
+}
+
+func reconcileResources(ctx context.Context, client dynamic.Interface, options structs.ControllerOptions, isLeader func() bool) error {
+	list, err := client.Resource(environmentResource).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, item := range list.Items {
+		if ctx.Err() != nil || !isLeader() {
+			return nil
+		}
+		var resource structs.EnvironmentResource
+		err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &resource)
+		if err != nil {
+			log.Printf("Environment %s/%s can't be read: %s", item.GetNamespace(), item.GetName(), err)
+			continue
+		}
+		err = reconcileResource(ctx, client.Resource(environmentResource).Namespace(resource.Namespace), &resource, options)
+		if err != nil {
+			log.Printf("Failed to reconcile environment %s/%s: %s", resource.Namespace, resource.Name, err)
+		}
+	}
+	return nil
+}
+
+// reconcileResource deploys the environment of the resource when its spec changes and deletes it together with the resource
+func reconcileResource(ctx context.Context, resources dynamic.ResourceInterface, resource *structs.EnvironmentResource, options structs.ControllerOptions) error {
+	if resource.DeletionTimestamp != nil {
+		if !slices.Contains(resource.Finalizers, structs.RESOURCE_FINALIZER) {
+			return nil
+		}
+		log.Printf("Deleting environment %s/%s", resource.Namespace, resource.Name)
+		err := cleanupResource(resource)
+		if err != nil {
+			return err
+		}
+		return updateResourceFinalizers(ctx, resources, resource, func(finalizers []string) []string {
+			return slices.Filter(nil, finalizers, func(finalizer string) bool {
+				return finalizer != structs.RESOURCE_FINALIZER
+			})
+		})
+	}
+
+	if !slices.Contains(resource.Finalizers, structs.RESOURCE_FINALIZER) {
+		err := updateResourceFinalizers(ctx, resources, resource, func(finalizers []string) []string {
+			return append(finalizers, structs.RESOURCE_FINALIZER)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	outdated, err := isResourceOutdated(resource)
+	if err != nil || !outdated {
+		return err
+	}
+	log.Printf("Deploying environment %s/%s (generation %d)", resource.Namespace, resource.Name, resource.Generation)
+	return deployResource(ctx, resources, resource, options)
+}
+
+// isResourceOutdated checks if the spec of the resource has changed since the last deploy or its environment is gone,
+// e.g. deleted by the ttl gc. Failed deploys are retried after a while.
+func isResourceOutdated(resource *structs.EnvironmentResource) (bool, error) {
+	if resource.Status.ObservedGeneration != resource.Generation {
+		return true, nil
+	}
+	failed := meta.FindStatusCondition(resource.Status.Conditions, structs.CONDITION_FAILED)
+	if failed != nil && failed.Status == metav1.ConditionTrue {
+		return time.Since(failed.LastTransitionTime.Time) > failedRetryInterval, nil
+	}
+	id, namespace := getResourceEnvironmentID(resource)
+	environment, err := findSavedEnvironment(namespace, id)
+	if err != nil {
+		return false, err
+	}
+	return environment == nil, nil
+}
+
+func deployResource(ctx context.Context, resources dynamic.ResourceInterface, resource *structs.EnvironmentResource, options structs.ControllerOptions) error {
+	resource.Status.Phase = structs.CONDITION_RENDERING
+	setResourceCondition(resource, structs.CONDITION_RENDERING, true, "Rendering", "Rendering the boxes")
+	setResourceCondition(resource, structs.CONDITION_DEPLOYING, false, "Rendering", "")
+	setResourceCondition(resource, structs.CONDITION_READY, false, "Rendering", "")
+	setResourceCondition(resource, structs.CONDITION_FAILED, false, "Rendering", "")
+	err := updateResourceStatus(ctx, resources, resource)
+	if err != nil {
+		return err
+	}
+
+	workdir, err := os.MkdirTemp("", "k8sbox-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workdir)
+
+	environment, err := renderResource(ctx, resource, workdir, options.AllowURLSources)
+	if err == nil {
+		err = validateResourceNamespaces(resource, *environment)
+	}
+	if err != nil {
+		return failResource(ctx, resources, resource, structs.CONDITION_RENDERING, err)
+	}
+
+	resource.Status.Phase = structs.CONDITION_DEPLOYING
+	setResourceCondition(resource, structs.CONDITION_RENDERING, false, "Rendered", "")
+	setResourceCondition(resource, structs.CONDITION_DEPLOYING, true, "Deploying", fmt.Sprintf("Deploying %d boxes", len(environment.Boxes)))
+	err = updateResourceStatus(ctx, resources, resource)
+	if err != nil {
+		return err
+	}
+
+	err = deploySpecEnvironment(environment, resource.Spec)
+	if environment.Revision > 0 {
+		resource.Status.Revision = environment.Revision
+		resource.Status.Boxes = getResourceBoxStatuses(*environment)
+	}
+	if err != nil {
+		return failResource(ctx, resources, resource, structs.CONDITION_DEPLOYING, err)
+	}
+
+	resource.Status.Phase = structs.CONDITION_READY
+	resource.Status.ObservedGeneration = resource.Generation
+	setResourceCondition(resource, structs.CONDITION_DEPLOYING, false, "Deployed", "")
+	setResourceCondition(resource, structs.CONDITION_READY, true, "Ready", fmt.Sprintf("Revision %d is ready", environment.Revision))
+	setResourceCondition(resource, structs.CONDITION_FAILED, false, "Ready", "")
+	return updateResourceStatus(ctx, resources, resource)
+}
+
+// deploySpecEnvironment applies the rendered environment of the spec and waits for it to become ready
+func deploySpecEnvironment(environment *structs.Environment, spec structs.EnvironmentSpec) error {
+	options := structs.DeployOptions{
+		Apply:       true,
+		Concurrency: structs.DEFAULT_CONCURRENCY,
+		Wait:        true,
+		Timeout:     structs.DEFAULT_WAIT_TIMEOUT,
+		Atomic:      spec.Atomic,
+	}
+	if len(spec.Timeout) > 0 {
+		timeout, err := time.ParseDuration(spec.Timeout)
+		if err != nil {
+			return err
+		}
+		options.Timeout = timeout
+	}
+	err := createNamespaceIfNotExists(environment.Namespace)
+	if err != nil {
+		return err
+	}
+	return deployEnvironment(environment, options)
+}
+
+// failResource records the failure of the step in the resource status and returns the failure
+func failResource(ctx context.Context, resources dynamic.ResourceInterface, resource *structs.EnvironmentResource, step string, failure error) error {
+	resource.Status.Phase = structs.CONDITION_FAILED
+	resource.Status.ObservedGeneration = resource.Generation
+	setResourceCondition(resource, step, false, "Failed", "")
+	setResourceCondition(resource, structs.CONDITION_READY, false, "Failed", "")
+	setResourceCondition(resource, structs.CONDITION_FAILED, true, step+"Failed", failure.Error())
+	err := updateResourceStatus(ctx, resources, resource)
+	if err != nil {
+		return err
+	}
+	return failure
+}
+
+func setResourceCondition(resource *structs.EnvironmentResource, conditionType string, status bool, reason string, message string) {
+	condition := metav1.Condition{
+		Type:               conditionType,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: resource.Generation,
+	}
+	if status {
+		condition.Status = metav1.ConditionTrue
+	}
+	if len(condition.Message) > conditionMessageLength {
+		condition.Message = condition.Message[:conditionMessageLength]
+	}
+	meta.SetStatusCondition(&resource.Status.Conditions, condition)
+}
+
+func getResourceBoxStatuses(environment structs.Environment) []structs.BoxStatus {
+	var statuses []structs.BoxStatus
+	for _, box := range environment.Boxes {
+		status := structs.BoxStatus{Name: box.Name, Namespace: box.Namespace}
+		readiness, err := getBoxReadiness(box)
+		if err != nil {
+			status.Failed = []string{err.Error()}
+		} else {
+			status.Ready, status.Total = readiness.Ready, readiness.Total
+			status.Pending, status.Failed = readiness.Pending, readiness.Failed
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// updateResourceStatus writes the status to the latest version of the resource, so spec changes made meanwhile don't conflict with it
+func updateResourceStatus(ctx context.Context, resources dynamic.ResourceInterface, resource *structs.EnvironmentResource) error {
+	status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&resource.Status)
+	if err != nil {
+		return err
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := resources.Get(ctx, resource.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		latest.Object["status"] = status
+		_, err = resources.UpdateStatus(ctx, latest, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func updateResourceFinalizers(ctx context.Context, resources dynamic.ResourceInterface, resource *structs.EnvironmentResource, update func([]string) []string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := resources.Get(ctx, resource.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		latest.SetFinalizers(update(latest.GetFinalizers()))
+		updated, err := resources.Update(ctx, latest, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		resource.Finalizers = updated.GetFinalizers()
+		return nil
+	})
+}
+
+// cleanupResource deletes the environment of the resource, its leftovers and its history
+func cleanupResource(resource *structs.EnvironmentResource) error {
+	id, namespace := getResourceEnvironmentID(resource)
+	environment, err := findSavedEnvironment(namespace, id)
+	if err != nil {
+		return err
+	}
+	if environment != nil {
+		err = deleteEnvironment(environment)
+	} else {
+		_, err = pruneEnvironment(namespace, id, false)
+	}
+	if err != nil {
+		return err
+	}
+	return deleteRevisions(namespace, id)
+}
+
+// getResourceEnvironmentID returns the id and the namespace of the resource environment.
+// The environment always lives in the namespace of the resource.
+func getResourceEnvironmentID(resource *structs.EnvironmentResource) (string, string) {
+	id := resource.Spec.ID
+	if len(id) == 0 {
+		id = resource.Name
+	}
+	return id, resource.Namespace
+}
+
+// validateResourceNamespaces makes sure the environment of the resource stays in the namespace of the resource,
+// so the resource can't deploy to the namespaces its author has no access to
+func validateResourceNamespaces(resource *structs.EnvironmentResource, environment structs.Environment) error {
+	var messages []string
+	if len(resource.Spec.Namespace) > 0 && resource.Spec.Namespace != resource.Namespace {
+		messages = append(messages, fmt.Sprintf("-> Namespace %s is not the resource namespace %s", resource.Spec.Namespace, resource.Namespace))
+	}
+	for _, box := range environment.Boxes {
+		if box.Namespace != resource.Namespace {
+			messages = append(messages, fmt.Sprintf("-> Box %s: Namespace %s is not the resource namespace %s", box.Name, box.Namespace, resource.Namespace))
+		}
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "\n\r"))
+	}
+	return nil
+}
+
+// renderResource turns the resource into a rendered environment. The charts and the manifests of the boxes are put into the workdir.
+// Only the variables of the spec are expanded, the variables of the k8sbox process never get into the render.
+// URL sources are downloaded only if allowURLSources is set.
+func renderResource(ctx context.Context, resource *structs.EnvironmentResource, workdir string, allowURLSources bool) (*structs.Environment, error) {
+	getenv := func(key string) string {
+		return resource.Spec.Variables[key]
+	}
+	environment := structs.Environment{
+		Name: os.Expand(resource.Spec.Name, getenv),
+		TTL:  os.Expand(resource.Spec.TTL, getenv),
+	}
+	environment.ID, environment.Namespace = getResourceEnvironmentID(resource)
+	environment.ID = os.Expand(environment.ID, getenv)
+	if len(environment.Name) == 0 {
+		environment.Name = resource.Name
+	}
+
+	for i, spec := range resource.Spec.Boxes {
+		box := structs.Box{
+			Name:      os.Expand(spec.Name, getenv),
+			Namespace: os.Expand(spec.Namespace, getenv),
+			Type:      spec.Type,
+		}
+		for _, dependency := range spec.DependsOn {
+			box.DependsOn = append(box.DependsOn, os.Expand(dependency, getenv))
+		}
+		dir := filepath.Join(workdir, strconv.Itoa(i))
+		err := os.MkdirAll(dir, 0750)
+		if err != nil {
+			return nil, err
+		}
+
+		switch spec.Type {
+		case structs.Helm():
+			if spec.Chart == nil {
+				return nil, fmt.Errorf("Box %d: Chart is missing", i)
+			}
+			archive, err := fetchSource(ctx, resource.Namespace, *spec.Chart, allowURLSources)
+			if err != nil {
+				return nil, fmt.Errorf("Box %d: %s", i, err)
+			}
+			box.Chart, err = expandChart(archive, filepath.Join(dir, "chart"))
+			if err != nil {
+				return nil, fmt.Errorf("Box %d: %s", i, err)
+			}
+			box.Values = filepath.Join(dir, "values.yaml")
+			err = os.WriteFile(box.Values, []byte(spec.Values+"\n"), 0644)
+			if err != nil {
+				return nil, err
+			}
+		case structs.Plain():
+			for j, application := range spec.Applications {
+				manifest := []byte(application.Manifest)
+				if application.From != nil {
+					manifest, err = fetchSource(ctx, resource.Namespace, *application.From, allowURLSources)
+					if err != nil {
+						return nil, fmt.Errorf("Box %d: application %s: %s", i, application.Name, err)
+					}
+				}
+				path := filepath.Join(dir, fmt.Sprintf("%d.yaml", j))
+				err = os.WriteFile(path, manifest, 0644)
+				if err != nil {
+					return nil, err
+				}
+				box.Applications = append(box.Applications, structs.Application{Name: os.Expand(application.Name, getenv), Chart: path})
+			}
+		}
+		environment.Boxes = append(environment.Boxes, box)
+	}
+
+	err := validateEnvironment(&environment)
+	if err != nil {
+		return nil, err
+	}
+	err = validateBoxes(environment.Boxes)
+	if err != nil {
+		return nil, err
+	}
+	for i := range environment.Boxes {
+		err = fillBoxFields(environment, &environment.Boxes[i], getenv, resource.Spec.Variables)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &environment, nil
+}
+
+// fetchSource returns the content of the file the source points to. Config maps are looked for in the namespace.
+// URLs are downloaded only if allowURLs is set, as the download comes from inside the cluster.
+func fetchSource(ctx context.Context, namespace string, source structs.Source, allowURLs bool) ([]byte, error) {
+	if source.ConfigMap != nil {
+		configMap, err := k8sclient.CoreV1().ConfigMaps(namespace).Get(ctx, source.ConfigMap.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if content, ok := configMap.BinaryData[source.ConfigMap.Key]; ok {
+			return content, nil
+		}
+		if content, ok := configMap.Data[source.ConfigMap.Key]; ok {
+			return []byte(content), nil
+		}
+		return nil, fmt.Errorf("Config map %s has no key %s", source.ConfigMap.Name, source.ConfigMap.Key)
+	}
+	if len(source.URL) == 0 {
+		return nil, errors.New("The source has neither a config map nor a URL")
+	}
+	if !allowURLs {
+		return nil, fmt.Errorf("URL sources are not allowed, %s can't be downloaded", source.URL)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sourceDownloadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", source.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to download %s: %s", source.URL, res.Status)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, maxSourceSize))
+}
+
+// expandChart extracts the chart archive into the dir and returns the path of its Chart.yaml
+func expandChart(archive []byte, dir string) (string, error) {
+	err := chartutil.Expand(dir, bytes.NewReader(archive))
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		chart := filepath.Join(dir, entry.Name(), chartutil.ChartfileName)
+		if _, err := os.Stat(chart); entry.IsDir() && err == nil {
+			return chart, nil
+		}
+	}
+	return "", errors.New("The chart archive has no Chart.yaml")
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenderResourceExpandsOnlySpecVariables(t *testing.T) {
+	t.Setenv("K8SBOX_TEST_SECRET", "leaked")
+	resource := &structs.EnvironmentResource{
+		ObjectMeta: metav1.ObjectMeta{Name: "review", Namespace: "review"},
+		Spec: structs.EnvironmentSpec{
+			Variables: map[string]string{"IMAGE": "nginx:1.25"},
+			Boxes: []structs.BoxSpec{{
+				Name: "web",
+				Type: structs.Plain(),
+				Applications: []structs.ApplicationSpec{{
+					Name: "config",
+					Manifest: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  image: ${IMAGE}
+  secret: "${K8SBOX_TEST_SECRET}"
+`,
+				}},
+			}},
+		},
+	}
+
+	environment, err := renderResource(context.Background(), resource, t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	render := environment.Boxes[0].HelmRender["config"]
+	if !strings.Contains(render, "image: nginx:1.25") {
+		t.Errorf("the spec variable is not expanded:\n%s", render)
+	}
+	if strings.Contains(render, "leaked") {
+		t.Errorf("the process variable got into the render:\n%s", render)
+	}
+}
+
+func TestRenderResourceRejectsURLSources(t *testing.T) {
+	resource := &structs.EnvironmentResource{
+		ObjectMeta: metav1.ObjectMeta{Name: "review", Namespace: "review"},
+		Spec: structs.EnvironmentSpec{
+			Boxes: []structs.BoxSpec{{
+				Name: "web",
+				Type: structs.Plain(),
+				Applications: []structs.ApplicationSpec{{
+					Name: "config",
+					From: &structs.Source{URL: "http://169.254.169.254/latest/meta-data"},
+				}},
+			}},
+		},
+	}
+
+	_, err := renderResource(context.Background(), resource, t.TempDir(), false)
+	if err == nil || !strings.Contains(err.Error(), "URL sources are not allowed") {
+		t.Errorf("renderResource() error = %v, want the URL source rejected", err)
+	}
+}
+
+func TestValidateResourceNamespaces(t *testing.T) {
+	tests := []struct {
+		name          string
+		specNamespace string
+		boxNamespaces []string
+		wantErr       bool
+	}{
+		{name: "resource namespace", boxNamespaces: []string{"review"}},
+		{name: "same spec namespace", specNamespace: "review", boxNamespaces: []string{"review"}},
+		{name: "other spec namespace", specNamespace: "kube-system", boxNamespaces: []string{"review"}, wantErr: true},
+		{name: "other box namespace", boxNamespaces: []string{"review", "kube-system"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resource := &structs.EnvironmentResource{
+				ObjectMeta: metav1.ObjectMeta{Name: "review", Namespace: "review"},
+				Spec:       structs.EnvironmentSpec{Namespace: tt.specNamespace},
+			}
+			var environment structs.Environment
+			for _, namespace := range tt.boxNamespaces {
+				environment.Boxes = append(environment.Boxes, structs.Box{Name: namespace, Namespace: namespace})
+			}
+			err := validateResourceNamespaces(resource, environment)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateResourceNamespaces() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
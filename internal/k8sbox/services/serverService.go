@@ -0,0 +1,713 @@
+// Package services contains buisness-logic methods of the models
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/twelvee/k8sbox/api"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"github.com/twelvee/k8sbox/pkg/k8sbox/utils"
+	"github.com/twelvee/k8sbox/web"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/strings/slices"
+)
+
+// operationRetention is the time a finished operation can still be polled
+const operationRetention = time.Hour
+
+// defaultLogLines and maxLogLines limit the number of log lines the logs of a pod are read with
+const (
+	defaultLogLines = 500
+	maxLogLines     = 10000
+)
+
+// shutdownTimeout limits the time the server waits for the open requests on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// apiServer serves the REST API. The deploys and the deletes run one at a time in the background,
+// the services share the cluster clients and the process variables.
+type apiServer struct {
+	token           string
+	allowURLSources bool
+	mutex           sync.Mutex
+	operations      map[string]*structs.Operation
+	running         sync.Mutex
+	background      sync.WaitGroup
+}
+
+// NewServerService creates a new ServerService
+func NewServerService() structs.ServerService {
+	return structs.ServerService{
+		Run: runServer,
+	}
+}
+
+// runServer serves the REST API until the context is done, the operations at hand are finished before it returns
+func runServer(ctx context.Context, options structs.ServerOptions) error {
+	server := &apiServer{
+		token:           options.Token,
+		allowURLSources: options.AllowURLSources,
+		operations:      make(map[string]*structs.Operation),
+	}
+	dashboard, err := newDashboardHandler()
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", dashboard)
+	mux.HandleFunc("/healthz", server.handleHealth)
+	mux.HandleFunc("/openapi.yaml", server.handleOpenAPI)
+	mux.HandleFunc("/v1/environments", server.authorize(server.handleEnvironments))
+	mux.HandleFunc("/v1/environments/", server.authorize(server.handleEnvironment))
+	mux.HandleFunc("/v1/operations", server.authorize(server.handleOperations))
+	mux.HandleFunc("/v1/operations/", server.authorize(server.handleOperation))
+
+	httpServer := &http.Server{
+		Addr:              options.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	served := make(chan error, 1)
+	go func() {
+		if len(options.TLSCert) > 0 {
+			served <- httpServer.ListenAndServeTLS(options.TLSCert, options.TLSKey)
+			return
+		}
+		served <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = httpServer.Shutdown(shutdownCtx)
+	log.Println("Waiting for the operations at hand to finish")
+	server.background.Wait()
+	return err
+}
+
+// authorize lets the request through only if it carries the server token
+func (a *apiServer) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="k8sbox"`)
+			writeError(w, http.StatusUnauthorized, errors.New("A valid bearer token is required"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// newDashboardHandler serves the embedded web dashboard. The assets are public, the dashboard asks for the token
+// and calls the REST API with it.
+func newDashboardHandler() (http.Handler, error) {
+	assets, err := fs.Sub(web.Static, "static")
+	if err != nil {
+		return nil, err
+	}
+	files := http.FileServer(http.FS(assets))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		files.ServeHTTP(w, r)
+	}), nil
+}
+
+func (a *apiServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (a *apiServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(api.OpenAPI)
+}
+
+// handleEnvironments lists the saved environments and deploys new ones
+func (a *apiServer) handleEnvironments(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		withHealth, _ := strconv.ParseBool(r.URL.Query().Get("health"))
+		environments, err := listEnvironments(r.URL.Query().Get("namespace"), withHealth)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, environments)
+	case http.MethodPost:
+		spec, err := readEnvironmentSpec(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		a.startOperation(w, structs.OPERATION_RUN, spec.Namespace, spec.ID, func(operation *structs.Operation) error {
+			environment, err := runEnvironmentSpec(spec, a.allowURLSources)
+			if environment != nil {
+				operation.Revision = environment.Revision
+			}
+			return err
+		})
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// environmentMethods are the methods allowed on /v1/environments/{namespace}/{id} and its subresources
+var environmentMethods = map[string][]string{
+	"":         {http.MethodGet, http.MethodDelete},
+	"objects":  {http.MethodGet},
+	"pods":     {http.MethodGet},
+	"logs":     {http.MethodGet},
+	"redeploy": {http.MethodPost},
+}
+
+// handleEnvironment describes and deletes the environment at /v1/environments/{namespace}/{id}.
+// Its objects, pods and logs are read and its redeploy is started at the subresources of the same path.
+func (a *apiServer) handleEnvironment(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/environments/"), "/")
+	if len(path) < 2 || len(path) > 3 || len(path[0]) == 0 || len(path[1]) == 0 {
+		writeError(w, http.StatusNotFound, errors.New("Use /v1/environments/{namespace}/{id}"))
+		return
+	}
+	subresource := ""
+	if len(path) == 3 {
+		subresource = path[2]
+	}
+	methods, ok := environmentMethods[subresource]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Environments have no %s", subresource))
+		return
+	}
+	if !slices.Contains(methods, r.Method) {
+		writeMethodNotAllowed(w, methods...)
+		return
+	}
+	namespace, id := path[0], path[1]
+	environment, err := findSavedEnvironment(namespace, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if environment == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Environment %s not found in namespace %s", id, namespace))
+		return
+	}
+
+	switch {
+	case subresource == "" && r.Method == http.MethodGet:
+		description, err := describeEnvironment(*environment)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, description)
+	case subresource == "":
+		withCRDs, _ := strconv.ParseBool(r.URL.Query().Get("crds"))
+		a.startOperation(w, structs.OPERATION_DELETE, namespace, id, func(operation *structs.Operation) error {
+			return deleteSavedEnvironmentByID(namespace, id, withCRDs)
+		})
+	case subresource == "objects":
+		objects, err := getEnvironmentObjects(*environment)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, objects)
+	case subresource == "pods":
+		pods, err := findEnvironmentPods(*environment)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, pods)
+	case subresource == "logs":
+		writePodLogs(w, r, *environment)
+	case subresource == "redeploy":
+		atomic, _ := strconv.ParseBool(r.URL.Query().Get("atomic"))
+		a.startOperation(w, structs.OPERATION_REDEPLOY, namespace, id, func(operation *structs.Operation) error {
+			environment, err := redeployEnvironment(namespace, id, structs.DeployOptions{
+				Apply:       true,
+				Concurrency: structs.DEFAULT_CONCURRENCY,
+				Wait:        true,
+				Timeout:     structs.DEFAULT_WAIT_TIMEOUT,
+				Atomic:      atomic,
+			})
+			if environment != nil {
+				operation.Revision = environment.Revision
+			}
+			return err
+		})
+	}
+}
+
+func (a *apiServer) handleOperations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	a.mutex.Lock()
+	operations := make([]structs.Operation, 0, len(a.operations))
+	for _, operation := range a.operations {
+		operations = append(operations, *operation)
+	}
+	a.mutex.Unlock()
+	sort.Slice(operations, func(i, j int) bool {
+		return operations[i].CreatedAt.After(operations[j].CreatedAt)
+	})
+	writeJSON(w, http.StatusOK, operations)
+}
+
+// handleOperation is polled at /v1/operations/{id} until the operation is finished
+func (a *apiServer) handleOperation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/v1/operations/")
+	a.mutex.Lock()
+	operation, ok := a.operations[id]
+	var snapshot structs.Operation
+	if ok {
+		snapshot = *operation
+	}
+	a.mutex.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Operation %s not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// startOperation queues the run in the background and answers with the operation to poll.
+// An environment has at most one unfinished operation at a time.
+func (a *apiServer) startOperation(w http.ResponseWriter, operationType structs.OperationType, namespace string, id string, run func(*structs.Operation) error) {
+	a.mutex.Lock()
+	now := time.Now()
+	for key, operation := range a.operations {
+		if operation.IsFinished() && now.Sub(*operation.FinishedAt) > operationRetention {
+			delete(a.operations, key)
+			continue
+		}
+		if !operation.IsFinished() && operation.Namespace == namespace && operation.Environment == id {
+			a.mutex.Unlock()
+			writeError(w, http.StatusConflict, fmt.Errorf("Environment %s already has the %s operation %s in progress", id, operation.Type, operation.ID))
+			return
+		}
+	}
+	operation := &structs.Operation{
+		ID:          utils.GetShortID(12),
+		Type:        operationType,
+		Environment: id,
+		Namespace:   namespace,
+		State:       structs.OPERATION_PENDING,
+		CreatedAt:   now,
+	}
+	a.operations[operation.ID] = operation
+	snapshot := *operation
+	a.background.Add(1)
+	a.mutex.Unlock()
+
+	go a.runOperation(operation, run)
+
+	w.Header().Set("Location", "/v1/operations/"+operation.ID)
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+func (a *apiServer) runOperation(operation *structs.Operation, run func(*structs.Operation) error) {
+	defer a.background.Done()
+	a.running.Lock()
+	defer a.running.Unlock()
+
+	a.setOperationState(operation, structs.OPERATION_RUNNING, nil)
+	log.Printf("Operation %s: %s environment %s/%s", operation.ID, operation.Type, operation.Namespace, operation.Environment)
+	// the run works on a copy, so polling the operation never races with it
+	result := *operation
+	err := run(&result)
+	a.mutex.Lock()
+	operation.Revision = result.Revision
+	a.mutex.Unlock()
+	if err != nil {
+		log.Printf("Operation %s failed: %s", operation.ID, err)
+		a.setOperationState(operation, structs.OPERATION_FAILED, err)
+		return
+	}
+	a.setOperationState(operation, structs.OPERATION_SUCCEEDED, nil)
+}
+
+func (a *apiServer) setOperationState(operation *structs.Operation, state structs.OperationState, err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	operation.State = state
+	if err != nil {
+		operation.Error = err.Error()
+	}
+	if operation.IsFinished() {
+		now := time.Now()
+		operation.FinishedAt = &now
+	}
+}
+
+// readEnvironmentSpec decodes the environment spec from a JSON or a toml body
+func readEnvironmentSpec(r *http.Request) (structs.EnvironmentSpec, error) {
+	var spec structs.EnvironmentSpec
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSourceSize))
+	if err != nil {
+		return spec, err
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case "application/json":
+		err = json.Unmarshal(body, &spec)
+	case "application/toml", "text/toml":
+		err = toml.Unmarshal(body, &spec)
+	default:
+		return spec, errors.New("The body must be either application/json or application/toml")
+	}
+	if err != nil {
+		return spec, err
+	}
+	if len(strings.TrimSpace(spec.ID)) == 0 || len(strings.TrimSpace(spec.Namespace)) == 0 {
+		return spec, errors.New("The environment id and namespace are required")
+	}
+	return spec, nil
+}
+
+// runEnvironmentSpec renders the environment of the spec and deploys it as the next revision
+func runEnvironmentSpec(spec structs.EnvironmentSpec, allowURLSources bool) (*structs.Environment, error) {
+	workdir, err := os.MkdirTemp("", "k8sbox-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workdir)
+
+	resource := &structs.EnvironmentResource{
+		ObjectMeta: metav1.ObjectMeta{Name: spec.ID, Namespace: spec.Namespace},
+		Spec:       spec,
+	}
+	environment, err := renderResource(context.Background(), resource, workdir, allowURLSources)
+	if err != nil {
+		return nil, err
+	}
+	return environment, deploySpecEnvironment(environment, spec)
+}
+
+// deleteSavedEnvironmentByID deletes the saved environment together with its history
+func deleteSavedEnvironmentByID(namespace string, id string, withCRDs bool) error {
+	environment, err := findSavedEnvironment(namespace, id)
+	if err != nil {
+		return err
+	}
+	if environment == nil {
+		return fmt.Errorf("Environment %s not found in namespace %s", id, namespace)
+	}
+	expandVariables(environment)
+	environment.Boxes = expandBoxVariables(environment.Boxes)
+	err = deleteEnvironment(environment)
+	if err != nil {
+		return err
+	}
+	if withCRDs {
+		err = deleteEnvironmentCRDs(environment)
+		if err != nil {
+			return err
+		}
+	}
+	return deleteRevisions(namespace, id)
+}
+
+// listEnvironments returns the saved environments of the namespace. An empty namespace stands for every namespace.
+// The live health of the environments is checked only on demand, it takes a request per object.
+func listEnvironments(namespace string, withHealth bool) ([]structs.EnvironmentSummary, error) {
+	environments, err := getAllSavedEnvironments()
+	if err != nil {
+		return nil, err
+	}
+	summaries := []structs.EnvironmentSummary{}
+	statuses := make(map[string]map[string]structs.EnvironmentStatus)
+	for _, environment := range environments {
+		if len(namespace) > 0 && environment.Namespace != namespace {
+			continue
+		}
+		if _, ok := statuses[environment.Namespace]; !ok {
+			statuses[environment.Namespace], err = getStatuses(environment.Namespace)
+			if err != nil {
+				return nil, err
+			}
+		}
+		summary := getEnvironmentSummary(environment)
+		if status, ok := statuses[environment.Namespace][environment.ID]; ok && status.Revision == environment.Revision {
+			summary.Phase = status.Phase
+		}
+		if withHealth {
+			summary.Health = getEnvironmentHealth(environment)
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Namespace != summaries[j].Namespace {
+			return summaries[i].Namespace < summaries[j].Namespace
+		}
+		return summaries[i].ID < summaries[j].ID
+	})
+	return summaries, nil
+}
+
+// describeEnvironment returns the saved environment together with the readiness and the problems of its boxes
+func describeEnvironment(environment structs.Environment) (structs.EnvironmentDescription, error) {
+	description := structs.EnvironmentDescription{
+		Environment: getEnvironmentSummary(environment),
+		Boxes:       []structs.BoxDescription{},
+	}
+	statuses, err := getStatuses(environment.Namespace)
+	if err != nil {
+		return description, err
+	}
+	if status, ok := statuses[environment.ID]; ok && status.Revision == environment.Revision {
+		description.Status = &status
+		description.Environment.Phase = status.Phase
+	}
+	var states []structs.BoxReadiness
+	unknown := false
+	for _, box := range environment.Boxes {
+		boxDescription := describeBox(box)
+		description.Boxes = append(description.Boxes, boxDescription)
+		unknown = unknown || len(boxDescription.Error) > 0
+		states = append(states, structs.BoxReadiness{
+			Box:     boxDescription.Name,
+			Ready:   boxDescription.Ready,
+			Total:   boxDescription.Total,
+			Pending: boxDescription.Pending,
+			Failed:  boxDescription.Failed,
+		})
+	}
+	description.Environment.Health = getReadinessHealth(states)
+	if unknown {
+		description.Environment.Health = structs.HEALTH_UNKNOWN
+	}
+	return description, nil
+}
+
+// getEnvironmentHealth sums up the live readiness of the environment boxes
+func getEnvironmentHealth(environment structs.Environment) structs.EnvironmentHealth {
+	var states []structs.BoxReadiness
+	for _, box := range environment.Boxes {
+		readiness, err := getBoxReadiness(box)
+		if err != nil {
+			return structs.HEALTH_UNKNOWN
+		}
+		states = append(states, readiness)
+	}
+	return getReadinessHealth(states)
+}
+
+func getReadinessHealth(states []structs.BoxReadiness) structs.EnvironmentHealth {
+	health := structs.HEALTH_READY
+	for _, state := range states {
+		if len(state.Failed) > 0 {
+			return structs.HEALTH_DEGRADED
+		}
+		if !state.IsReady() {
+			health = structs.HEALTH_PROGRESSING
+		}
+	}
+	return health
+}
+
+// getEnvironmentObjects returns the live state of every object from the environment render
+func getEnvironmentObjects(environment structs.Environment) ([]structs.ObjectStatus, error) {
+	objects := []structs.ObjectStatus{}
+	for _, box := range environment.Boxes {
+		statuses, err := getBoxObjectStatuses(box)
+		if err != nil {
+			return nil, fmt.Errorf("Box %s: %s", box.Name, err)
+		}
+		objects = append(objects, statuses...)
+	}
+	return objects, nil
+}
+
+// findEnvironmentPods returns the pods labelled with the environment in the namespaces of its boxes
+func findEnvironmentPods(environment structs.Environment) ([]structs.PodSummary, error) {
+	var namespaces []string
+	for _, box := range environment.Boxes {
+		if !slices.Contains(namespaces, box.Namespace) {
+			namespaces = append(namespaces, box.Namespace)
+		}
+	}
+	sort.Strings(namespaces)
+
+	pods := []structs.PodSummary{}
+	for _, namespace := range namespaces {
+		list, err := k8sclient.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{
+			LabelSelector: utils.GetEnvironmentSelector(environment.ID, environment.Namespace),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range list.Items {
+			summary := structs.PodSummary{
+				Box:        pod.Labels[structs.LABEL_BOX],
+				Namespace:  pod.Namespace,
+				Name:       pod.Name,
+				Phase:      string(pod.Status.Phase),
+				Containers: []string{},
+				CreatedAt:  getOptionalTime(pod.CreationTimestamp.Time),
+			}
+			for _, condition := range pod.Status.Conditions {
+				if condition.Type == corev1.PodReady {
+					summary.Ready = condition.Status == corev1.ConditionTrue
+				}
+			}
+			for _, status := range pod.Status.ContainerStatuses {
+				summary.Restarts += status.RestartCount
+			}
+			for _, container := range pod.Spec.InitContainers {
+				summary.Containers = append(summary.Containers, container.Name)
+			}
+			for _, container := range pod.Spec.Containers {
+				summary.Containers = append(summary.Containers, container.Name)
+			}
+			pods = append(pods, summary)
+		}
+	}
+	return pods, nil
+}
+
+// writePodLogs streams the logs of a pod labelled with the environment, the logs of other pods are never read
+func writePodLogs(w http.ResponseWriter, r *http.Request, environment structs.Environment) {
+	query := r.URL.Query()
+	namespace, name := query.Get("namespace"), query.Get("pod")
+	pods, err := findEnvironmentPods(environment)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var pod *structs.PodSummary
+	for i := range pods {
+		if pods[i].Namespace == namespace && pods[i].Name == name {
+			pod = &pods[i]
+		}
+	}
+	if pod == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Pod %s/%s doesn't belong to environment %s", namespace, name, environment.ID))
+		return
+	}
+
+	options := &corev1.PodLogOptions{Container: query.Get("container")}
+	if len(options.Container) == 0 && len(pod.Containers) > 0 {
+		options.Container = pod.Containers[len(pod.Containers)-1]
+	}
+	if !slices.Contains(pod.Containers, options.Container) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Pod %s has no container %s", name, options.Container))
+		return
+	}
+	tailLines := int64(defaultLogLines)
+	if tail, err := strconv.ParseInt(query.Get("tail"), 10, 64); err == nil && tail > 0 && tail < maxLogLines {
+		tailLines = tail
+	}
+	options.TailLines = &tailLines
+	options.Previous, _ = strconv.ParseBool(query.Get("previous"))
+
+	stream, err := k8sclient.CoreV1().Pods(namespace).GetLogs(name, options).Stream(r.Context())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer stream.Close()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err = io.Copy(w, stream)
+	if err != nil {
+		log.Printf("Failed to write the logs of pod %s/%s: %s", namespace, name, err)
+	}
+}
+
+func describeBox(box structs.Box) structs.BoxDescription {
+	description := structs.BoxDescription{Name: box.Name, Namespace: box.Namespace, Type: box.Type}
+	readiness, err := getBoxReadiness(box)
+	if err != nil {
+		description.Error = err.Error()
+		return description
+	}
+	description.Ready, description.Total = readiness.Ready, readiness.Total
+	description.Pending, description.Failed = readiness.Pending, readiness.Failed
+	diagnosis, err := diagnoseBox(box)
+	if err != nil {
+		description.Error = err.Error()
+		return description
+	}
+	for _, object := range diagnosis.Objects {
+		description.Problems = append(description.Problems, structs.ObjectProblem{
+			Kind:     object.Kind,
+			Name:     object.Name,
+			Reason:   object.Reason,
+			Problems: object.Problems,
+			Events:   object.Events,
+		})
+	}
+	return description
+}
+
+func getEnvironmentSummary(environment structs.Environment) structs.EnvironmentSummary {
+	summary := structs.EnvironmentSummary{
+		ID:        environment.ID,
+		Name:      environment.Name,
+		Namespace: environment.Namespace,
+		Boxes:     []string{},
+		Revision:  environment.Revision,
+		TTL:       environment.TTL,
+		CreatedAt: getOptionalTime(environment.CreatedAt),
+		UpdatedAt: getOptionalTime(environment.UpdatedAt),
+		ExpiresAt: getOptionalTime(environment.ExpiresAt),
+	}
+	for _, box := range environment.Boxes {
+		summary.Boxes = append(summary.Boxes, box.Name)
+	}
+	return summary
+}
+
+// getOptionalTime returns nil for the zero time, so it is left out of the JSON
+func getOptionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Printf("Failed to write the response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.New("Method not allowed"))
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+)
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{name: "valid token", header: "Bearer s3cret", want: http.StatusOK},
+		{name: "missing header", header: "", want: http.StatusUnauthorized},
+		{name: "wrong token", header: "Bearer s3cre", want: http.StatusUnauthorized},
+		{name: "token without the bearer scheme", header: "s3cret", want: http.StatusUnauthorized},
+		{name: "basic credentials", header: "Basic s3cret", want: http.StatusUnauthorized},
+		{name: "empty bearer token", header: "Bearer ", want: http.StatusUnauthorized},
+	}
+	server := &apiServer{token: "s3cret"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := server.authorize(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusOK)
+			})
+			r := httptest.NewRequest(http.MethodGet, "/v1/environments", nil)
+			if len(tt.header) > 0 {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("authorize() status = %d, want %d", w.Code, tt.want)
+			}
+			if called != (tt.want == http.StatusOK) {
+				t.Errorf("authorize() called the handler = %v", called)
+			}
+			if tt.want == http.StatusUnauthorized {
+				if got := w.Header().Get("WWW-Authenticate"); got != `Bearer realm="k8sbox"` {
+					t.Errorf("authorize() WWW-Authenticate = %q", got)
+				}
+				if !strings.Contains(w.Body.String(), "A valid bearer token is required") {
+					t.Errorf("authorize() body = %s", w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestGetReadinessHealth(t *testing.T) {
+	tests := []struct {
+		name   string
+		states []structs.BoxReadiness
+		want   structs.EnvironmentHealth
+	}{
+		{name: "no boxes", want: structs.HEALTH_READY},
+		{
+			name: "every box ready",
+			states: []structs.BoxReadiness{
+				{Box: "db", Ready: 2, Total: 2},
+				{Box: "api", Ready: 1, Total: 1},
+			},
+			want: structs.HEALTH_READY,
+		},
+		{
+			name: "box in progress",
+			states: []structs.BoxReadiness{
+				{Box: "db", Ready: 2, Total: 2},
+				{Box: "api", Ready: 0, Total: 1, Pending: []string{"Deployment api: 0 of 1 replicas updated"}},
+			},
+			want: structs.HEALTH_PROGRESSING,
+		},
+		{
+			name: "failed box wins over the progressing ones",
+			states: []structs.BoxReadiness{
+				{Box: "db", Ready: 0, Total: 2, Pending: []string{"StatefulSet db: 0 of 1 replicas ready"}},
+				{Box: "api", Ready: 0, Total: 1, Failed: []string{"Job migrate: failed: BackoffLimitExceeded"}},
+			},
+			want: structs.HEALTH_DEGRADED,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getReadinessHealth(tt.states); got != tt.want {
+				t.Errorf("getReadinessHealth() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDashboardHandler(t *testing.T) {
+	handler, err := newDashboardHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{name: "index page", path: "/", want: http.StatusOK},
+		{name: "script", path: "/app.js", want: http.StatusOK},
+		{name: "stylesheet", path: "/style.css", want: http.StatusOK},
+		{name: "missing asset", path: "/missing.js", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.want {
+				t.Errorf("dashboard %s status = %d, want %d", tt.path, w.Code, tt.want)
+			}
+			if got := w.Header().Get("Content-Security-Policy"); got != "default-src 'self'; frame-ancestors 'none'" {
+				t.Errorf("dashboard %s Content-Security-Policy = %q", tt.path, got)
+			}
+			if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("dashboard %s X-Content-Type-Options = %q", tt.path, got)
+			}
+		})
+	}
+}
@@ -6,12 +6,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
-	"github.com/google/go-cmp/cmp"
 	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
 	"github.com/twelvee/k8sbox/pkg/k8sbox/utils"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	applyv1 "k8s.io/client-go/applyconfigurations/core/v1"
 	applymetav1 "k8s.io/client-go/applyconfigurations/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -26,19 +31,46 @@ func NewStorageService() structs.StorageService {
 		DeleteBox:              deleteSavedBox,
 		GetEnvironments:        getSavedEnvironments,
 		GetEnvironment:         getSavedEnvironment,
+		GetAllEnvironments:     getAllSavedEnvironments,
 		IsEnvironmentSaved:     isEnvironmentSaved,
+		SaveRevision:           saveRevision,
+		GetRevisions:           getRevisions,
+		DeleteRevisions:        deleteRevisions,
+		SaveStatuses:           saveStatuses,
+		GetStatuses:            getStatuses,
 	}
 }
 
 const CONFIG_MAP_NAME string = "k8sbox-configmap"
 
+// HISTORY_CONFIG_MAP_PREFIX is the name prefix of the config maps that keep the environment revisions
+const HISTORY_CONFIG_MAP_PREFIX string = "k8sbox-history-"
+
+// FIELD_MANAGER is the field manager name k8sbox uses for server-side apply
+const FIELD_MANAGER string = "k8sbox"
+
+// CONTROLLER_FIELD_MANAGER is the field manager name the controller uses, so its statuses never conflict with the saved environments
+const CONTROLLER_FIELD_MANAGER string = "k8sbox-controller"
+
+// STATUS_ANNOTATION_PREFIX is the prefix of the environments config map annotations that keep the statuses observed by the controller
+const STATUS_ANNOTATION_PREFIX string = "status.k8sbox.run/"
+
 var storageType structs.StorageType
 
-func ensureStorageAvailable(namespace string) error {
+// storageMutex guards the saved environments from concurrent updates of the boxes installed at once
+var storageMutex sync.Mutex
+
+func getStorageTypeFromEnv() structs.StorageType {
 	// Make volume is default storage type
-	storageType = structs.TYPE_VOLUME
 	if os.Getenv("K8SBOX_STORAGE_TYPE") == string(structs.TYPE_FILESYSTEM) {
-		storageType = structs.TYPE_FILESYSTEM
+		return structs.TYPE_FILESYSTEM
+	}
+	return structs.TYPE_VOLUME
+}
+
+func ensureStorageAvailable(namespace string) error {
+	storageType = getStorageTypeFromEnv()
+	if storageType == structs.TYPE_FILESYSTEM {
 		err := ensureSaveFileExists()
 		return err
 	}
@@ -82,6 +114,8 @@ func createEmptyConfigMap(k8sclient *kubernetes.Clientset, namespace string) err
 }
 
 func saveEnvironment(environment structs.Environment) error {
+	storageMutex.Lock()
+	defer storageMutex.Unlock()
 	err := ensureStorageAvailable(environment.Namespace)
 	if err != nil {
 		return err
@@ -131,7 +165,7 @@ func updateConfigMap(configMap *corev1.ConfigMap, savedEnvironments []structs.En
 		},
 		BinaryData: newBinaryData,
 	}
-	_, err := k8sclient.CoreV1().ConfigMaps(namespace).Apply(context.Background(), &applyConfig, v1.ApplyOptions{FieldManager: "k8sbox"})
+	_, err := k8sclient.CoreV1().ConfigMaps(namespace).Apply(context.Background(), &applyConfig, v1.ApplyOptions{FieldManager: FIELD_MANAGER})
 	if err != nil {
 		return err
 	}
@@ -190,6 +224,8 @@ func isEnvironmentSavedToVolume(environment structs.Environment) (bool, error) {
 }
 
 func deleteSavedEnvironment(environment structs.Environment) error {
+	storageMutex.Lock()
+	defer storageMutex.Unlock()
 	err := ensureStorageAvailable(environment.Namespace)
 	if err != nil {
 		return err
@@ -224,6 +260,8 @@ func deleteEnvironmentFromVolume(environment structs.Environment) error {
 }
 
 func deleteSavedBox(environment structs.Environment, box structs.Box) error {
+	storageMutex.Lock()
+	defer storageMutex.Unlock()
 	err := ensureStorageAvailable(environment.Namespace)
 	if err != nil {
 		return err
@@ -257,7 +295,8 @@ func deleteBoxFromVolume(environment structs.Environment, box structs.Box) error
 
 	if currentEnvironment != -1 {
 		for j, b := range savedEnvironments[currentEnvironment].Boxes {
-			if cmp.Equal(b, box) {
+			// the saved box carries what its install has found out, e.g. the cluster-scoped objects it has created
+			if b.Name == box.Name && b.Namespace == box.Namespace {
 				savedEnvironments[currentEnvironment].Boxes[j] = savedEnvironments[currentEnvironment].Boxes[len(savedEnvironments[currentEnvironment].Boxes)-1]
 				savedEnvironments[currentEnvironment].Boxes = savedEnvironments[currentEnvironment].Boxes[:len(savedEnvironments[currentEnvironment].Boxes)-1]
 				break
@@ -323,3 +362,249 @@ func getEnvironmentFromVolume(namespace string, id string) (*structs.Environment
 	}
 	return nil, fmt.Errorf("No environment found.")
 }
+
+func getAllSavedEnvironments() ([]structs.Environment, error) {
+	if getStorageTypeFromEnv() == structs.TYPE_FILESYSTEM {
+		return utils.GetEnvironments()
+	}
+	return getAllEnvironmentsFromVolumes()
+}
+
+func getAllEnvironmentsFromVolumes() ([]structs.Environment, error) {
+	configMaps, err := k8sclient.CoreV1().ConfigMaps("").List(context.Background(), v1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", CONFIG_MAP_NAME).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var environments []structs.Environment
+	for _, configMap := range configMaps.Items {
+		savedEnvironments, err := getEnvironmentsFromConfigMap(configMap)
+		if err != nil {
+			return nil, err
+		}
+		environments = append(environments, savedEnvironments...)
+	}
+	return environments, nil
+}
+
+// findSavedEnvironment looks for the saved environment without creating the storage. It returns nil if nothing is saved.
+func findSavedEnvironment(namespace string, id string) (*structs.Environment, error) {
+	if getStorageTypeFromEnv() == structs.TYPE_FILESYSTEM {
+		saved, err := utils.IsEnvironmentSaved(id)
+		if err != nil || !saved {
+			return nil, err
+		}
+		return utils.GetEnvironment(id)
+	}
+	configMap, err := k8sclient.CoreV1().ConfigMaps(namespace).Get(context.Background(), CONFIG_MAP_NAME, v1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e, ok := configMap.BinaryData[id]
+	if !ok {
+		return nil, nil
+	}
+	var env structs.Environment
+	err = json.Unmarshal(e, &env)
+	if err != nil {
+		return nil, err
+	}
+	return &env, nil
+}
+
+func saveRevision(revision structs.Revision) error {
+	storageMutex.Lock()
+	defer storageMutex.Unlock()
+	if getStorageTypeFromEnv() == structs.TYPE_FILESYSTEM {
+		return utils.SaveRevision(revision)
+	}
+	return saveRevisionToVolume(revision)
+}
+
+func getRevisionConfigMapName(id string, number int) string {
+	return fmt.Sprintf("%s%s-%d", HISTORY_CONFIG_MAP_PREFIX, utils.GetEnvironmentKey(id), number)
+}
+
+func getHistorySelector(id string) string {
+	return fmt.Sprintf("%s=%s", structs.LABEL_HISTORY, utils.GetEnvironmentKey(id))
+}
+
+// saveRevisionToVolume keeps every revision in a config map of its own, so the history never hits the size limit of a config map.
+// Only the latest revisions are kept.
+func saveRevisionToVolume(revision structs.Revision) error {
+	namespace, id := revision.Environment.Namespace, revision.Environment.ID
+	err := applyRevisionConfigMap(revision)
+	if err != nil {
+		return err
+	}
+
+	list, err := k8sclient.CoreV1().ConfigMaps(namespace).List(context.Background(), v1.ListOptions{LabelSelector: getHistorySelector(id)})
+	if err != nil {
+		return err
+	}
+	configMaps := list.Items
+	sort.Slice(configMaps, func(i, j int) bool {
+		return getConfigMapRevision(configMaps[i]) < getConfigMapRevision(configMaps[j])
+	})
+	for len(configMaps) > structs.MAX_REVISIONS {
+		err := k8sclient.CoreV1().ConfigMaps(namespace).Delete(context.Background(), configMaps[0].Name, v1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+		configMaps = configMaps[1:]
+	}
+	return nil
+}
+
+func applyRevisionConfigMap(revision structs.Revision) error {
+	content, err := json.Marshal(revision)
+	if err != nil {
+		return err
+	}
+	namespace := revision.Environment.Namespace
+	name := getRevisionConfigMapName(revision.Environment.ID, revision.Number)
+	apiVersion, kind := "v1", "ConfigMap"
+	applyConfig := applyv1.ConfigMapApplyConfiguration{
+		TypeMetaApplyConfiguration: applymetav1.TypeMetaApplyConfiguration{
+			Kind:       &kind,
+			APIVersion: &apiVersion,
+		},
+		ObjectMetaApplyConfiguration: &applymetav1.ObjectMetaApplyConfiguration{
+			Name:        &name,
+			Namespace:   &namespace,
+			Labels:      map[string]string{structs.LABEL_HISTORY: utils.GetEnvironmentKey(revision.Environment.ID)},
+			Annotations: map[string]string{structs.ANNOTATION_REVISION: strconv.Itoa(revision.Number)},
+		},
+		BinaryData: map[string][]byte{"revision": content},
+	}
+	_, err = k8sclient.CoreV1().ConfigMaps(namespace).Apply(context.Background(), &applyConfig, v1.ApplyOptions{FieldManager: FIELD_MANAGER})
+	return err
+}
+
+func getConfigMapRevision(configMap corev1.ConfigMap) int {
+	number, _ := strconv.Atoi(configMap.Annotations[structs.ANNOTATION_REVISION])
+	return number
+}
+
+func getRevisions(namespace string, id string) ([]structs.Revision, error) {
+	if getStorageTypeFromEnv() == structs.TYPE_FILESYSTEM {
+		return utils.GetRevisions(id)
+	}
+	return getRevisionsFromVolume(namespace, id)
+}
+
+func getRevisionsFromVolume(namespace string, id string) ([]structs.Revision, error) {
+	list, err := k8sclient.CoreV1().ConfigMaps(namespace).List(context.Background(), v1.ListOptions{LabelSelector: getHistorySelector(id)})
+	if err != nil {
+		return nil, err
+	}
+	var revisions []structs.Revision
+	for _, configMap := range list.Items {
+		var revision structs.Revision
+		err := json.Unmarshal(configMap.BinaryData["revision"], &revision)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return utils.TrimRevisions(revisions, len(revisions)), nil
+}
+
+func deleteRevisions(namespace string, id string) error {
+	if getStorageTypeFromEnv() == structs.TYPE_FILESYSTEM {
+		return utils.RemoveRevisions(id)
+	}
+	return k8sclient.CoreV1().ConfigMaps(namespace).DeleteCollection(context.Background(), v1.DeleteOptions{}, v1.ListOptions{LabelSelector: getHistorySelector(id)})
+}
+
+// getNextRevisionNumber returns the number the next revision of the environment gets
+func getNextRevisionNumber(namespace string, id string) (int, error) {
+	revisions, err := getRevisions(namespace, id)
+	if err != nil {
+		return 0, err
+	}
+	next := 1
+	if len(revisions) > 0 {
+		next = revisions[len(revisions)-1].Number + 1
+	}
+	// the revision of the saved environment counts even if its history write has failed
+	environment, err := findSavedEnvironment(namespace, id)
+	if err != nil {
+		return 0, err
+	}
+	if environment != nil && environment.Revision >= next {
+		next = environment.Revision + 1
+	}
+	return next, nil
+}
+
+// saveStatuses replaces the statuses of the namespace environments observed by the controller
+func saveStatuses(namespace string, statuses []structs.EnvironmentStatus) error {
+	if getStorageTypeFromEnv() == structs.TYPE_FILESYSTEM {
+		return utils.SaveStatuses(namespace, statuses)
+	}
+	_, err := k8sclient.CoreV1().ConfigMaps(namespace).Get(context.Background(), CONFIG_MAP_NAME, v1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		// every environment of the namespace is gone
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	annotations := make(map[string]string)
+	for _, status := range statuses {
+		content, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		annotations[STATUS_ANNOTATION_PREFIX+status.ID] = string(content)
+	}
+	name := CONFIG_MAP_NAME
+	apiVersion, kind := "v1", "ConfigMap"
+	applyConfig := applyv1.ConfigMapApplyConfiguration{
+		TypeMetaApplyConfiguration: applymetav1.TypeMetaApplyConfiguration{
+			Kind:       &kind,
+			APIVersion: &apiVersion,
+		},
+		ObjectMetaApplyConfiguration: &applymetav1.ObjectMetaApplyConfiguration{
+			Name:        &name,
+			Namespace:   &namespace,
+			Annotations: annotations,
+		},
+	}
+	// the controller owns only the status annotations, the statuses it doesn't apply anymore are removed
+	_, err = k8sclient.CoreV1().ConfigMaps(namespace).Apply(context.Background(), &applyConfig, v1.ApplyOptions{FieldManager: CONTROLLER_FIELD_MANAGER, Force: true})
+	return err
+}
+
+// getStatuses returns the statuses of the namespace environments observed by the controller keyed by the environment id
+func getStatuses(namespace string) (map[string]structs.EnvironmentStatus, error) {
+	if getStorageTypeFromEnv() == structs.TYPE_FILESYSTEM {
+		return utils.GetStatuses()
+	}
+	statuses := make(map[string]structs.EnvironmentStatus)
+	configMap, err := k8sclient.CoreV1().ConfigMaps(namespace).Get(context.Background(), CONFIG_MAP_NAME, v1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return statuses, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range configMap.Annotations {
+		if !strings.HasPrefix(key, STATUS_ANNOTATION_PREFIX) {
+			continue
+		}
+		var status structs.EnvironmentStatus
+		err := json.Unmarshal([]byte(value), &status)
+		if err != nil {
+			return nil, err
+		}
+		statuses[status.ID] = status
+	}
+	return statuses, nil
+}
@@ -2,6 +2,7 @@
 package structs
 
 import (
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -28,6 +29,7 @@ type ApplicationService struct {
 	DescribeHPA                   func(*kubernetes.Clientset, string, string) error
 	DescribeService               func(*kubernetes.Clientset, string, string) error
 	DescribeIngress               func(*kubernetes.Clientset, string, string) error
+	DescribeCustomResource        func(runtime.Object, string) error
 }
 
 // GetApplicationAliases return a slice of application model name aliases
@@ -49,4 +51,5 @@ const (
 	KIND_HPA                    string = "HorizontalPodAutoscaler"
 	KIND_SERVICE                string = "Service"
 	KIND_INGRESS                string = "Ingress"
+	KIND_PVC                    string = "PersistentVolumeClaim"
 )
@@ -2,18 +2,75 @@
 package structs
 
 import (
+	"strings"
+
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // Box is your box in a struct
 type Box struct {
-	Type         string            `toml:"type"`
-	Applications []Application     `toml:"applications"`
-	Chart        string            `toml:"chart"`
-	Values       string            `toml:"values"`
-	Namespace    string            `toml:"namespace"`
-	Name         string            `toml:"name"`
-	HelmRender   map[string]string `toml:"-"`
+	Type           string            `toml:"type"`
+	Applications   []Application     `toml:"applications"`
+	Chart          string            `toml:"chart"`
+	Values         string            `toml:"values"`
+	ValuesFiles    []string          `toml:"values_files"`
+	Namespace      string            `toml:"namespace"`
+	Name           string            `toml:"name"`
+	DependsOn      []string          `toml:"depends_on"`
+	HelmRender     map[string]string `toml:"-"`
+	CRDRender      map[string]string `toml:"-"`
+	ClusterObjects []ClusterObject   `toml:"-"`
+}
+
+// ClusterObject is a cluster-scoped k8s object created by a box
+type ClusterObject struct {
+	Group string
+	Kind  string
+	Name  string
+}
+
+// BoxReadiness is a readiness state of the box objects
+type BoxReadiness struct {
+	Box   string
+	Ready int
+	Total int
+	// Pending describes the objects that are not ready yet
+	Pending []string
+	// Failed describes the objects that will never become ready
+	Failed []string
+}
+
+// IsReady checks if every box object is ready
+func (r BoxReadiness) IsReady() bool {
+	return r.Ready == r.Total
+}
+
+// BoxDiagnosis explains why the box objects are unhealthy
+type BoxDiagnosis struct {
+	Box     string
+	Objects []ObjectDiagnosis
+}
+
+// IsHealthy checks if nothing is wrong with the box objects
+func (d BoxDiagnosis) IsHealthy() bool {
+	return len(d.Objects) == 0
+}
+
+// ObjectDiagnosis explains why a single box object is unhealthy
+type ObjectDiagnosis struct {
+	Kind     string
+	Name     string
+	Reason   string
+	Problems []string
+	Events   []string
+	Logs     []ContainerLogs
+}
+
+// ContainerLogs are the last log lines of a failing container
+type ContainerLogs struct {
+	Pod       string
+	Container string
+	Lines     []string
 }
 
 // BoxService is a public BoxService
@@ -25,6 +82,16 @@ type BoxService struct {
 	UninstallBox            func(Environment, Box) ([]*runtime.Object, error)
 	DescribeBoxApplications func(Environment, Box) error
 	ExpandBoxVariables      func([]Box) []Box
+	DiagnoseBox             func(Box) (BoxDiagnosis, error)
+}
+
+// GetValuesFiles returns every values file of the box in the order they should be applied
+func (b Box) GetValuesFiles() []string {
+	var files []string
+	if len(strings.TrimSpace(b.Values)) != 0 {
+		files = append(files, b.Values)
+	}
+	return append(files, b.ValuesFiles...)
 }
 
 // Helm is helm string getter
@@ -0,0 +1,55 @@
+// Package structs contain every k8sbox public structs
+package structs
+
+import (
+	"context"
+	"time"
+)
+
+// ControllerOptions is a set of options of the long-running controller
+type ControllerOptions struct {
+	// Interval is the time between two reconciles of the saved environments
+	Interval time.Duration
+	// LeaseName is the name of the lease replicas compete for, only the holder reconciles
+	LeaseName string
+	// LeaseNamespace is the namespace of the lease
+	LeaseNamespace string
+	// Identity tells the replica apart from the other ones
+	Identity string
+	// AllowURLSources lets the operator download the charts and the manifests of the resources from their URLs
+	AllowURLSources bool
+}
+
+// DEFAULT_RECONCILE_INTERVAL is the default time between two reconciles of the controller
+const DEFAULT_RECONCILE_INTERVAL time.Duration = time.Minute
+
+// DEFAULT_LEASE_NAME is the default name of the controller lease
+const DEFAULT_LEASE_NAME string = "k8sbox-controller"
+
+// EnvironmentPhase is an enum that has all possible outcomes of reconciling an environment
+type EnvironmentPhase string
+
+const (
+	PHASE_HEALTHY   EnvironmentPhase = "Healthy"
+	PHASE_RECREATED EnvironmentPhase = "Recreated"
+	PHASE_DRIFTED   EnvironmentPhase = "Drifted"
+	PHASE_DEPLOYING EnvironmentPhase = "Deploying"
+	PHASE_EXPIRED   EnvironmentPhase = "Expired"
+	PHASE_FAILED    EnvironmentPhase = "Failed"
+)
+
+// EnvironmentStatus is the state of a saved environment observed by the controller
+type EnvironmentStatus struct {
+	ID        string           `json:"id"`
+	Revision  int              `json:"revision"`
+	Phase     EnvironmentPhase `json:"phase"`
+	Recreated []string         `json:"recreated,omitempty"`
+	Drifted   []string         `json:"drifted,omitempty"`
+	Message   string           `json:"message,omitempty"`
+	CheckedAt time.Time        `json:"checkedAt"`
+}
+
+// ControllerService is a public ControllerService
+type ControllerService struct {
+	Run func(context.Context, ControllerOptions) error
+}
@@ -1,6 +1,8 @@
 // Package structs contain every k8sbox public structs
 package structs
 
+import "time"
+
 // Environment is your environment in a struct
 type Environment struct {
 	Name             string            `toml:"name"`
@@ -10,15 +12,124 @@ type Environment struct {
 	Variables        string            `toml:"variables"`
 	LoadBoxesFrom    string            `toml:"load_boxes_from"`
 	LoadBoxesHeaders map[string]Header `toml:"load_boxes_headers"`
+	TTL              string            `toml:"ttl"`
+	Revision         int               `toml:"-"`
+	CreatedAt        time.Time         `toml:"-"`
+	UpdatedAt        time.Time         `toml:"-"`
+	ExpiresAt        time.Time         `toml:"-"`
+}
+
+// GetTTL returns the time the environment lives after its last deploy. Zero means it never expires.
+func (e Environment) GetTTL() (time.Duration, error) {
+	if len(e.TTL) == 0 {
+		return 0, nil
+	}
+	return time.ParseDuration(e.TTL)
 }
 
+// IsExpired checks if the environment has outlived its TTL
+func (e Environment) IsExpired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt)
+}
+
+// DeployOptions is a set of options that changes the way an environment is deployed
+type DeployOptions struct {
+	// Apply updates the saved environment with server-side apply instead of recreating it
+	Apply bool
+	// DryRun previews the deployment without persisting anything
+	DryRun DryRunStrategy
+	// Concurrency limits the number of boxes installed at once
+	Concurrency int
+	// Wait makes the deploy wait for every box to become ready. Boxes always wait for their dependencies.
+	Wait bool
+	// Timeout limits the time spent waiting for the environment and the dependencies to become ready, DEFAULT_WAIT_TIMEOUT if not set
+	Timeout time.Duration
+	// Progress reports the readiness of the boxes while waiting for them
+	Progress func([]BoxReadiness)
+	// Atomic rolls back every change on failure and saves the environment only when the deploy succeeds
+	Atomic bool
+	// Previous is the saved environment deleted before the environment is recreated, its creation time and expiry are kept
+	Previous *Environment
+}
+
+// DEFAULT_CONCURRENCY is the default number of boxes k8sbox works with at once
+const DEFAULT_CONCURRENCY int = 4
+
+// DEFAULT_WAIT_TIMEOUT is the default time k8sbox waits for the environment to become ready
+const DEFAULT_WAIT_TIMEOUT time.Duration = 5 * time.Minute
+
+// DryRunStrategy is an enum that has all available dry run strategies
+type DryRunStrategy string
+
+const (
+	DRY_RUN_NONE   DryRunStrategy = ""
+	DRY_RUN_CLIENT DryRunStrategy = "client"
+	DRY_RUN_SERVER DryRunStrategy = "server"
+)
+
+// GetDryRunStrategies return a slice of available dry run strategies
+func GetDryRunStrategies() []string {
+	return []string{string(DRY_RUN_CLIENT), string(DRY_RUN_SERVER)}
+}
+
+// ObjectReport is a predicted outcome of deploying a single box object
+type ObjectReport struct {
+	Box       string
+	Kind      string
+	Namespace string
+	Name      string
+	Action    ObjectAction
+	Reason    string
+	Diff      string
+}
+
+// EnvironmentDiff is a difference between the environment specification and the cluster
+type EnvironmentDiff struct {
+	Objects      []ObjectReport
+	RemovedBoxes []string
+}
+
+// HasChanges checks if deploying the environment would change anything in the cluster
+func (d EnvironmentDiff) HasChanges() bool {
+	if len(d.RemovedBoxes) > 0 {
+		return true
+	}
+	for _, o := range d.Objects {
+		if o.Action != ACTION_UNCHANGED {
+			return true
+		}
+	}
+	return false
+}
+
+// ObjectAction is an enum that has all possible outcomes of deploying an object
+type ObjectAction string
+
+const (
+	ACTION_CREATE    ObjectAction = "create"
+	ACTION_CHANGE    ObjectAction = "change"
+	ACTION_UNCHANGED ObjectAction = "unchanged"
+	ACTION_DELETE    ObjectAction = "delete"
+	ACTION_REJECT    ObjectAction = "reject"
+)
+
 // EnvironmentService is a public EnvironmentService
 type EnvironmentService struct {
-	DeployEnvironment          func(*Environment) error
+	DeployEnvironment          func(*Environment, DeployOptions) error
 	DeleteEnvironment          func(*Environment) error
+	DeleteEnvironmentCRDs      func(*Environment) error
 	ValidateEnvironment        func(*Environment) error
 	ExpandVariables            func(*Environment)
 	PrepareToWorkWithNamespace func(namespace string) error
+	ConnectToCluster           func(namespace string) error
+	DryRunEnvironment          func(*Environment, DryRunStrategy) ([]ObjectReport, error)
+	DiffEnvironment            func(*Environment) (EnvironmentDiff, error)
+	TestEnvironment            func(*Environment) error
+	RollbackEnvironment        func(namespace string, id string, revision int, options DeployOptions) (*Environment, error)
+	PruneEnvironment           func(namespace string, id string, dryRun bool) ([]ObjectReport, error)
+	GetExpiredEnvironments     func(namespace string) ([]Environment, error)
+	ExtendEnvironment          func(namespace string, id string, duration time.Duration) (*Environment, error)
+	ReconcileEnvironment       func(*Environment) (EnvironmentStatus, error)
 }
 
 // GetEnvironmentAliases return a slice of environment model name aliases
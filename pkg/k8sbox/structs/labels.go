@@ -0,0 +1,12 @@
+// Package structs contain every k8sbox public structs
+package structs
+
+// Labels and annotations k8sbox puts on every object it deploys, so the objects can be found with plain kubectl
+const (
+	LABEL_MANAGED_BY            = "app.kubernetes.io/managed-by"
+	LABEL_ENVIRONMENT_ID        = "k8sbox.run/environment-id"
+	LABEL_ENVIRONMENT_NAMESPACE = "k8sbox.run/environment-namespace"
+	LABEL_BOX                   = "k8sbox.run/box"
+	ANNOTATION_REVISION         = "k8sbox.run/revision"
+	MANAGED_BY                  = "k8sbox"
+)
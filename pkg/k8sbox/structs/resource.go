@@ -0,0 +1,108 @@
+// Package structs contain every k8sbox public structs
+package structs
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The Environment custom resource the operator reconciles
+const (
+	RESOURCE_GROUP     = "k8sbox.run"
+	RESOURCE_VERSION   = "v1alpha1"
+	RESOURCE_KIND      = "Environment"
+	RESOURCE_PLURAL    = "environments"
+	RESOURCE_FINALIZER = "k8sbox.run/finalizer"
+)
+
+// DEFAULT_OPERATOR_LEASE_NAME is the default name of the operator lease
+const DEFAULT_OPERATOR_LEASE_NAME string = "k8sbox-operator"
+
+// DEFAULT_OPERATOR_INTERVAL is the default time between two looks of the operator for changed resources
+const DEFAULT_OPERATOR_INTERVAL time.Duration = 15 * time.Second
+
+// The conditions of the Environment custom resource
+const (
+	CONDITION_RENDERING = "Rendering"
+	CONDITION_DEPLOYING = "Deploying"
+	CONDITION_READY     = "Ready"
+	CONDITION_FAILED    = "Failed"
+)
+
+// EnvironmentResource is the Environment custom resource, its spec mirrors the environment toml
+type EnvironmentResource struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              EnvironmentSpec           `json:"spec"`
+	Status            EnvironmentResourceStatus `json:"status,omitempty"`
+}
+
+// EnvironmentSpec is the desired environment. The id defaults to the resource name, the namespace to the resource namespace.
+// The REST API takes the same spec as a JSON or a toml body.
+type EnvironmentSpec struct {
+	ID        string            `json:"id,omitempty" toml:"id"`
+	Name      string            `json:"name,omitempty" toml:"name"`
+	Namespace string            `json:"namespace,omitempty" toml:"namespace"`
+	TTL       string            `json:"ttl,omitempty" toml:"ttl"`
+	Variables map[string]string `json:"variables,omitempty" toml:"variables"`
+	Boxes     []BoxSpec         `json:"boxes" toml:"boxes"`
+	Atomic    bool              `json:"atomic,omitempty" toml:"atomic"`
+	Timeout   string            `json:"timeout,omitempty" toml:"timeout"`
+}
+
+// BoxSpec is a box of the Environment custom resource. Helm boxes take the chart archive and inline values,
+// plain boxes take the manifests of their applications.
+type BoxSpec struct {
+	Name         string            `json:"name,omitempty" toml:"name"`
+	Namespace    string            `json:"namespace,omitempty" toml:"namespace"`
+	Type         string            `json:"type" toml:"type"`
+	DependsOn    []string          `json:"dependsOn,omitempty" toml:"depends_on"`
+	Chart        *Source           `json:"chart,omitempty" toml:"chart"`
+	Values       string            `json:"values,omitempty" toml:"values"`
+	Applications []ApplicationSpec `json:"applications,omitempty" toml:"applications"`
+}
+
+// ApplicationSpec is an application of a plain box, its manifest is either inline or taken from the source
+type ApplicationSpec struct {
+	Name     string  `json:"name" toml:"name"`
+	Manifest string  `json:"manifest,omitempty" toml:"manifest"`
+	From     *Source `json:"from,omitempty" toml:"from"`
+}
+
+// Source points to a file kept in a config map of the resource namespace or served by a URL
+type Source struct {
+	ConfigMap *ConfigMapKey `json:"configMap,omitempty" toml:"config_map"`
+	URL       string        `json:"url,omitempty" toml:"url"`
+}
+
+// ConfigMapKey is a key of a config map
+type ConfigMapKey struct {
+	Name string `json:"name" toml:"name"`
+	Key  string `json:"key" toml:"key"`
+}
+
+// EnvironmentResourceStatus is the observed state of the Environment custom resource
+type EnvironmentResourceStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Phase              string             `json:"phase,omitempty"`
+	Revision           int                `json:"revision,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	Boxes              []BoxStatus        `json:"boxes,omitempty"`
+}
+
+// BoxStatus is the observed state of a box of the Environment custom resource
+type BoxStatus struct {
+	Name      string   `json:"name"`
+	Namespace string   `json:"namespace"`
+	Ready     int      `json:"ready"`
+	Total     int      `json:"total"`
+	Pending   []string `json:"pending,omitempty"`
+	Failed    []string `json:"failed,omitempty"`
+}
+
+// OperatorService is a public OperatorService
+type OperatorService struct {
+	Run func(context.Context, ControllerOptions) error
+}
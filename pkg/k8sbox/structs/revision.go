@@ -0,0 +1,30 @@
+// Package structs contain every k8sbox public structs
+package structs
+
+import "time"
+
+// Revision is a state of the environment after one of its deploys
+type Revision struct {
+	Number      int            `json:"number"`
+	Environment Environment    `json:"environment"`
+	Timestamp   time.Time      `json:"timestamp"`
+	Status      RevisionStatus `json:"status"`
+	Description string         `json:"description"`
+	Message     string         `json:"message,omitempty"`
+	Invoker     string         `json:"invoker"`
+}
+
+// RevisionStatus is an enum that has all possible outcomes of a deploy
+type RevisionStatus string
+
+const (
+	REVISION_SUCCEEDED RevisionStatus = "succeeded"
+	REVISION_FAILED    RevisionStatus = "failed"
+)
+
+// MAX_REVISIONS is the number of the latest revisions kept in the environment history
+const MAX_REVISIONS int = 10
+
+// LABEL_HISTORY marks the config maps that keep the environment revisions, they are annotated with the revision number.
+// It differs from the ownership labels, so the history is never pruned together with the environment objects.
+const LABEL_HISTORY = "k8sbox.run/history"
@@ -0,0 +1,156 @@
+// Package structs contain every k8sbox public structs
+package structs
+
+import (
+	"context"
+	"time"
+)
+
+// ServerOptions is a set of options of the REST API server
+type ServerOptions struct {
+	// Address is the host:port the server listens on
+	Address string
+	// Token is the bearer token every API request must carry
+	Token string
+	// TLSCert and TLSKey are the certificate files of the server, the server speaks plain HTTP without them
+	TLSCert string
+	TLSKey  string
+	// AllowURLSources lets the server download the charts and the manifests of the posted specs from their URLs
+	AllowURLSources bool
+}
+
+// DEFAULT_SERVER_ADDRESS is the default address of the REST API server
+const DEFAULT_SERVER_ADDRESS string = ":8080"
+
+// SERVER_TOKEN_VARIABLE is the variable the REST API server takes its token from
+const SERVER_TOKEN_VARIABLE string = "K8SBOX_API_TOKEN"
+
+// OperationType is an enum that has all long-running operations of the REST API
+type OperationType string
+
+const (
+	OPERATION_RUN      OperationType = "run"
+	OPERATION_REDEPLOY OperationType = "redeploy"
+	OPERATION_DELETE   OperationType = "delete"
+)
+
+// OperationState is an enum that has all states of a long-running operation
+type OperationState string
+
+const (
+	OPERATION_PENDING   OperationState = "pending"
+	OPERATION_RUNNING   OperationState = "running"
+	OPERATION_SUCCEEDED OperationState = "succeeded"
+	OPERATION_FAILED    OperationState = "failed"
+)
+
+// Operation is a deploy or a delete of an environment the REST API runs in the background
+type Operation struct {
+	ID          string         `json:"id"`
+	Type        OperationType  `json:"type"`
+	Environment string         `json:"environment"`
+	Namespace   string         `json:"namespace"`
+	State       OperationState `json:"state"`
+	Revision    int            `json:"revision,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	FinishedAt  *time.Time     `json:"finishedAt,omitempty"`
+}
+
+// IsFinished checks if the operation has either succeeded or failed
+func (o Operation) IsFinished() bool {
+	return o.State == OPERATION_SUCCEEDED || o.State == OPERATION_FAILED
+}
+
+// EnvironmentSummary is a saved environment as the REST API lists it
+type EnvironmentSummary struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Boxes     []string          `json:"boxes"`
+	Revision  int               `json:"revision"`
+	TTL       string            `json:"ttl,omitempty"`
+	CreatedAt *time.Time        `json:"createdAt,omitempty"`
+	UpdatedAt *time.Time        `json:"updatedAt,omitempty"`
+	ExpiresAt *time.Time        `json:"expiresAt,omitempty"`
+	Phase     EnvironmentPhase  `json:"phase,omitempty"`
+	Health    EnvironmentHealth `json:"health,omitempty"`
+}
+
+// EnvironmentHealth is an enum that has all live states of an environment
+type EnvironmentHealth string
+
+const (
+	HEALTH_READY       EnvironmentHealth = "Ready"
+	HEALTH_PROGRESSING EnvironmentHealth = "Progressing"
+	HEALTH_DEGRADED    EnvironmentHealth = "Degraded"
+	HEALTH_UNKNOWN     EnvironmentHealth = "Unknown"
+)
+
+// EnvironmentDescription is a saved environment together with the live state of its boxes
+type EnvironmentDescription struct {
+	Environment EnvironmentSummary `json:"environment"`
+	Status      *EnvironmentStatus `json:"status,omitempty"`
+	Boxes       []BoxDescription   `json:"boxes"`
+}
+
+// BoxDescription is the live state of a box
+type BoxDescription struct {
+	Name      string          `json:"name"`
+	Namespace string          `json:"namespace"`
+	Type      string          `json:"type"`
+	Ready     int             `json:"ready"`
+	Total     int             `json:"total"`
+	Pending   []string        `json:"pending,omitempty"`
+	Failed    []string        `json:"failed,omitempty"`
+	Problems  []ObjectProblem `json:"problems,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// ObjectProblem explains why a single box object is unhealthy
+type ObjectProblem struct {
+	Kind     string   `json:"kind"`
+	Name     string   `json:"name"`
+	Reason   string   `json:"reason"`
+	Problems []string `json:"problems,omitempty"`
+	Events   []string `json:"events,omitempty"`
+}
+
+// ObjectState is an enum that has all live states of a box object
+type ObjectState string
+
+const (
+	OBJECT_READY   ObjectState = "Ready"
+	OBJECT_PENDING ObjectState = "Pending"
+	OBJECT_FAILED  ObjectState = "Failed"
+	OBJECT_PRESENT ObjectState = "Present"
+	OBJECT_MISSING ObjectState = "Missing"
+)
+
+// ObjectStatus is the live state of an object from the box render. Objects k8sbox can't wait for are only Present or Missing.
+type ObjectStatus struct {
+	Box       string      `json:"box"`
+	Kind      string      `json:"kind"`
+	Namespace string      `json:"namespace,omitempty"`
+	Name      string      `json:"name"`
+	State     ObjectState `json:"state"`
+	Reason    string      `json:"reason,omitempty"`
+	CreatedAt *time.Time  `json:"createdAt,omitempty"`
+}
+
+// PodSummary is a pod labelled with the environment, its logs can be read through the REST API
+type PodSummary struct {
+	Box        string     `json:"box"`
+	Namespace  string     `json:"namespace"`
+	Name       string     `json:"name"`
+	Phase      string     `json:"phase"`
+	Ready      bool       `json:"ready"`
+	Restarts   int32      `json:"restarts"`
+	Containers []string   `json:"containers"`
+	CreatedAt  *time.Time `json:"createdAt,omitempty"`
+}
+
+// ServerService is a public ServerService
+type ServerService struct {
+	Run func(context.Context, ServerOptions) error
+}
@@ -22,5 +22,11 @@ type StorageService struct {
 	DeleteBox              func(Environment, Box) error
 	GetEnvironments        func(string) ([]Environment, error)
 	GetEnvironment         func(namespace string, id string) (*Environment, error)
+	GetAllEnvironments     func() ([]Environment, error)
 	IsEnvironmentSaved     func(Environment) (bool, error)
+	SaveRevision           func(Revision) error
+	GetRevisions           func(namespace string, id string) ([]Revision, error)
+	DeleteRevisions        func(namespace string, id string) error
+	SaveStatuses           func(namespace string, statuses []EnvironmentStatus) error
+	GetStatuses            func(namespace string) (map[string]EnvironmentStatus, error)
 }
@@ -2,14 +2,23 @@
 package utils
 
 import (
-	"k8s.io/apimachinery/pkg/api/meta"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	yamlserializer "k8s.io/apimachinery/pkg/runtime/serializer/yaml"
 	"k8s.io/cli-runtime/pkg/resource"
-	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/restmapper"
 	"k8s.io/kubectl/pkg/scheme"
+	"sigs.k8s.io/yaml"
 )
 
 // NewRestClient provide a k8s rest client
@@ -25,9 +34,13 @@ func NewRestClient(restConfig rest.Config, gv schema.GroupVersion) (rest.Interfa
 	return rest.RESTClientFor(&restConfig)
 }
 
-// CreateRuntimeObject will create k8s runtime object from helm chart (template)
+// CreateRuntimeObject will create k8s runtime object from helm chart (template).
+// Kinds unknown to the kubectl scheme (custom resources) are decoded as unstructured objects.
 func CreateRuntimeObject(yaml string) (runtime.Object, error) {
 	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode([]byte(yaml), nil, nil)
+	if runtime.IsNotRegisteredError(err) {
+		obj, _, err = yamlserializer.NewDecodingSerializer(unstructured.UnstructuredJSONScheme).Decode([]byte(yaml), nil, nil)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -35,29 +48,188 @@ func CreateRuntimeObject(yaml string) (runtime.Object, error) {
 	return obj, nil
 }
 
-// CreateRestMapper will create a REST mapper that tracks information about the available resources in the cluster.
-func CreateRestMapper(k8sclientSet *kubernetes.Clientset, obj runtime.Object) (*meta.RESTMapping, error) {
-	groupResources, err := restmapper.GetAPIGroupResources(k8sclientSet.Discovery())
+// ConvertHelmRenderToYaml will convert helmcharts replaced render (tempalte) to a list of k8s manifests sorted by template name
+func ConvertHelmRenderToYaml(m map[string]string) []string {
+	render := CleanHelmRender(m)
+	names := make([]string, 0, len(render))
+	for name := range render {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b []string
+	for _, name := range names {
+		b = append(b, render[name])
+	}
+	return b
+}
+
+// GetBoxManifests will return every manifest of the box, CRDs go first
+func GetBoxManifests(box structs.Box) []string {
+	return append(ConvertHelmRenderToYaml(box.CRDRender), GetInstallManifests(box.HelmRender)...)
+}
+
+// GetInstallManifests will return the render manifests in the order they should be installed (the same order helm uses).
+// Hooks are not a part of the install, they are run separately.
+func GetInstallManifests(render map[string]string) []string {
+	var manifests []string
+	for _, manifest := range ConvertHelmRenderToYaml(render) {
+		if !IsHookManifest(manifest) {
+			manifests = append(manifests, manifest)
+		}
+	}
+	return SortManifestsByKind(manifests, releaseutil.InstallOrder)
+}
+
+// IsHookManifest checks if the manifest is annotated as a helm hook
+func IsHookManifest(manifest string) bool {
+	var header struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	err := yaml.Unmarshal([]byte(manifest), &header)
+	if err != nil {
+		return false
+	}
+	_, ok := header.Metadata.Annotations[release.HookAnnotation]
+	return ok
+}
+
+// GetHookManifests will return every hook manifest of the render sorted by the hook weight
+func GetHookManifests(render map[string]string) ([]string, error) {
+	hooks, _, err := releaseutil.SortManifests(CleanHelmRender(render), nil, releaseutil.InstallOrder)
 	if err != nil {
 		return nil, err
 	}
-	rm := restmapper.NewDiscoveryRESTMapper(groupResources)
+	var manifests []string
+	for _, hook := range hooks {
+		manifests = append(manifests, hook.Manifest)
+	}
+	return manifests, nil
+}
 
-	gvk := obj.GetObjectKind().GroupVersionKind()
-	gk := schema.GroupKind{Group: gvk.Group, Kind: gvk.Kind}
-	mapping, err := rm.RESTMapping(gk, gvk.Version)
+// GetBoxHooks will return the render hooks fired on the event in the order they should run (by weight, then by kind)
+func GetBoxHooks(render map[string]string, event release.HookEvent) ([]*release.Hook, error) {
+	hooks, _, err := releaseutil.SortManifests(CleanHelmRender(render), nil, releaseutil.InstallOrder)
 	if err != nil {
 		return nil, err
 	}
+	var fired []*release.Hook
+	for _, hook := range hooks {
+		for _, e := range hook.Events {
+			if e == event {
+				fired = append(fired, hook)
+				break
+			}
+		}
+	}
+	return fired, nil
+}
+
+// GetUninstallManifests will return the render manifests in the order they should be uninstalled (the reversed install order)
+func GetUninstallManifests(render map[string]string) []string {
+	manifests := GetInstallManifests(render)
+	for i, j := 0, len(manifests)-1; i < j; i, j = i+1, j-1 {
+		manifests[i], manifests[j] = manifests[j], manifests[i]
+	}
+	return manifests
+}
 
-	return mapping, nil
+// SortManifestsByKind will sort manifests by their kind in the given order.
+// Unknown kinds go last, sorted alphabetically. Manifests of the same kind keep their order.
+func SortManifestsByKind(manifests []string, order releaseutil.KindSortOrder) []string {
+	ordering := make(map[string]int, len(order))
+	for i, kind := range order {
+		ordering[kind] = i
+	}
+	kinds := make([]string, len(manifests))
+	for i, manifest := range manifests {
+		kinds[i] = GetManifestKind(manifest)
+	}
+	indexes := make([]int, len(manifests))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		kindA, kindB := kinds[indexes[a]], kinds[indexes[b]]
+		orderA, knownA := ordering[kindA]
+		orderB, knownB := ordering[kindB]
+		if knownA && knownB {
+			return orderA < orderB
+		}
+		if knownA != knownB {
+			return knownA
+		}
+		return kindA < kindB
+	})
+
+	sorted := make([]string, len(manifests))
+	for i, index := range indexes {
+		sorted[i] = manifests[index]
+	}
+	return sorted
 }
 
-// ConvertHelmRenderToYaml will convert helmcharts replaced render (tempalte) to k8s yaml format
-func ConvertHelmRenderToYaml(m map[string]string) []string {
-	var b []string
-	for _, value := range m {
-		b = append(b, value)
+// GetManifestKind will return the kind of the k8s manifest or an empty string if it can't be parsed
+func GetManifestKind(manifest string) string {
+	var header struct {
+		Kind string `json:"kind"`
 	}
-	return b
+	err := yaml.Unmarshal([]byte(manifest), &header)
+	if err != nil {
+		return ""
+	}
+	return header.Kind
+}
+
+// CleanHelmRender will split multi-document templates and drop notes, partials and empty documents from the render
+func CleanHelmRender(render map[string]string) map[string]string {
+	cleaned := make(map[string]string)
+	for name, content := range render {
+		if !IsManifestTemplate(name) {
+			continue
+		}
+		documents := SplitYamlDocuments(content)
+		for i, document := range documents {
+			key := name
+			if len(documents) > 1 {
+				key = fmt.Sprintf("%s#%d", name, i)
+			}
+			cleaned[key] = document
+		}
+	}
+	return cleaned
+}
+
+// IsManifestTemplate checks if the rendered template may contain k8s manifests (it is neither notes nor a partial)
+func IsManifestTemplate(name string) bool {
+	if strings.HasSuffix(name, "NOTES.txt") {
+		return false
+	}
+	return !strings.HasPrefix(path.Base(name), "_")
+}
+
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---([ \t].*)?$`)
+
+// SplitYamlDocuments will split a multi-document yaml into separate documents
+func SplitYamlDocuments(content string) []string {
+	var documents []string
+	for _, document := range yamlDocumentSeparator.Split(content, -1) {
+		if isEmptyYamlDocument(document) {
+			continue
+		}
+		documents = append(documents, document)
+	}
+	return documents
+}
+
+func isEmptyYamlDocument(document string) bool {
+	for _, line := range strings.Split(document, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) != 0 && !strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+	return true
 }
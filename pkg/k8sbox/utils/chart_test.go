@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitYamlDocuments(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "single document",
+			content: "kind: Service\n",
+			want:    []string{"kind: Service\n"},
+		},
+		{
+			name:    "multiple documents",
+			content: "kind: Service\n---\nkind: Deployment\n",
+			want:    []string{"kind: Service\n", "\nkind: Deployment\n"},
+		},
+		{
+			name:    "leading separator and source comment",
+			content: "---\n# Source: web/templates/service.yaml\nkind: Service\n",
+			want:    []string{"\n# Source: web/templates/service.yaml\nkind: Service\n"},
+		},
+		{
+			name:    "separator with a trailing comment",
+			content: "kind: Service\n--- # next\nkind: Deployment\n",
+			want:    []string{"kind: Service\n", "\nkind: Deployment\n"},
+		},
+		{
+			name:    "empty and comment only documents are dropped",
+			content: "---\n\n---\n# nothing here\n---\nkind: Service\n---\n",
+			want:    []string{"\nkind: Service\n"},
+		},
+		{
+			name:    "dashes inside a value are not a separator",
+			content: "kind: ConfigMap\ndata:\n  text: |\n    a---b\n",
+			want:    []string{"kind: ConfigMap\ndata:\n  text: |\n    a---b\n"},
+		},
+		{
+			name:    "empty content",
+			content: "",
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SplitYamlDocuments(tt.content); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitYamlDocuments() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanHelmRender(t *testing.T) {
+	tests := []struct {
+		name   string
+		render map[string]string
+		want   map[string]string
+	}{
+		{
+			name: "single document keeps the template name",
+			render: map[string]string{
+				"web/templates/service.yaml": "kind: Service\n",
+			},
+			want: map[string]string{
+				"web/templates/service.yaml": "kind: Service\n",
+			},
+		},
+		{
+			name: "multi-document template is split",
+			render: map[string]string{
+				"web/templates/all.yaml": "kind: Service\n---\nkind: Deployment\n",
+			},
+			want: map[string]string{
+				"web/templates/all.yaml#0": "kind: Service\n",
+				"web/templates/all.yaml#1": "\nkind: Deployment\n",
+			},
+		},
+		{
+			name: "notes and partials are dropped",
+			render: map[string]string{
+				"web/templates/NOTES.txt":     "Thanks for installing web",
+				"web/templates/_helpers.tpl":  "",
+				"web/templates/service.yaml":  "kind: Service\n",
+				"web/templates/disabled.yaml": "\n# disabled\n",
+			},
+			want: map[string]string{
+				"web/templates/service.yaml": "kind: Service\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CleanHelmRender(tt.render); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CleanHelmRender() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetInstallManifests(t *testing.T) {
+	tests := []struct {
+		name   string
+		render map[string]string
+		want   []string
+	}{
+		{
+			name: "kinds follow the helm install order",
+			render: map[string]string{
+				"web/templates/a-deployment.yaml": "kind: Deployment\n",
+				"web/templates/b-service.yaml":    "kind: Service\n",
+				"web/templates/c-configmap.yaml":  "kind: ConfigMap\n",
+				"web/templates/d-namespace.yaml":  "kind: Namespace\n",
+			},
+			want: []string{"kind: Namespace\n", "kind: ConfigMap\n", "kind: Service\n", "kind: Deployment\n"},
+		},
+		{
+			name: "same kinds keep the template name order",
+			render: map[string]string{
+				"web/templates/b.yaml": "kind: Service\nmetadata:\n  name: b\n",
+				"web/templates/a.yaml": "kind: Service\nmetadata:\n  name: a\n",
+			},
+			want: []string{"kind: Service\nmetadata:\n  name: a\n", "kind: Service\nmetadata:\n  name: b\n"},
+		},
+		{
+			name: "unknown kinds go last sorted by kind",
+			render: map[string]string{
+				"web/templates/a.yaml": "kind: Widget\n",
+				"web/templates/b.yaml": "kind: Gadget\n",
+				"web/templates/c.yaml": "kind: Deployment\n",
+			},
+			want: []string{"kind: Deployment\n", "kind: Gadget\n", "kind: Widget\n"},
+		},
+		{
+			name: "hooks are left out",
+			render: map[string]string{
+				"web/templates/job.yaml":     "kind: Job\nmetadata:\n  annotations:\n    helm.sh/hook: pre-install\n",
+				"web/templates/service.yaml": "kind: Service\n",
+			},
+			want: []string{"kind: Service\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetInstallManifests(tt.render); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetInstallManifests() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetUninstallManifests(t *testing.T) {
+	render := map[string]string{
+		"web/templates/deployment.yaml": "kind: Deployment\n",
+		"web/templates/service.yaml":    "kind: Service\n",
+		"web/templates/namespace.yaml":  "kind: Namespace\n",
+		"web/templates/widget.yaml":     "kind: Widget\n",
+	}
+	want := []string{"kind: Widget\n", "kind: Deployment\n", "kind: Service\n", "kind: Namespace\n"}
+	if got := GetUninstallManifests(render); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetUninstallManifests() = %q, want %q", got, want)
+	}
+}
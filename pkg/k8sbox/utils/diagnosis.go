@@ -0,0 +1,38 @@
+// Package utils is a useful utils that k8sbox use. Methods are usually exported
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+)
+
+// FormatBoxDiagnosis will format the box diagnosis as a concise human readable text
+func FormatBoxDiagnosis(diagnosis structs.BoxDiagnosis) string {
+	var result []string
+	result = append(result, fmt.Sprintf("Diagnosis of box %s:", diagnosis.Box))
+	for _, o := range diagnosis.Objects {
+		header := fmt.Sprintf("-> %s %s", o.Kind, o.Name)
+		if len(o.Reason) > 0 {
+			header = fmt.Sprintf("%s: %s", header, o.Reason)
+		}
+		result = append(result, header)
+		for _, p := range o.Problems {
+			result = append(result, fmt.Sprintf("   %s", p))
+		}
+		if len(o.Events) > 0 {
+			result = append(result, "   Warning events:")
+			for _, e := range o.Events {
+				result = append(result, fmt.Sprintf("     %s", e))
+			}
+		}
+		for _, l := range o.Logs {
+			result = append(result, fmt.Sprintf("   Last logs of %s/%s:", l.Pod, l.Container))
+			for _, line := range l.Lines {
+				result = append(result, fmt.Sprintf("     | %s", line))
+			}
+		}
+	}
+	return strings.Join(result, "\r\n")
+}
@@ -2,17 +2,22 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
-	"github.com/google/go-cmp/cmp"
 	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
 )
 
 const saveDir = "/tmp/k8sbox_saves"
 const savesFile = "/tmp/k8sbox_saves/save"
+const historyDir = "/tmp/k8sbox_saves/history"
+const statusFile = "/tmp/k8sbox_saves/status"
 
 func EnsureSaveFileAvailable() error {
 	// TODO: check useless calls of this method
@@ -33,7 +38,7 @@ func EnsureSaveFileAvailable() error {
 	return nil
 }
 
-// IsBoxSaved check if the box is already saved or not
+// IsBoxSaved check if the box is already saved or not, boxes are matched by their name and namespace
 func IsBoxSaved(environmentID string, sbox structs.Box) (bool, error) {
 	err := EnsureSaveFileAvailable()
 	if err != nil {
@@ -52,7 +57,7 @@ func IsBoxSaved(environmentID string, sbox structs.Box) (bool, error) {
 	for _, env := range targets {
 		if env.ID == environmentID {
 			for _, box := range env.Boxes {
-				if cmp.Equal(box, sbox) {
+				if box.Name == sbox.Name && box.Namespace == sbox.Namespace {
 					return true, nil
 				}
 			}
@@ -86,35 +91,39 @@ func IsEnvironmentSaved(id string) (bool, error) {
 	return false, nil
 }
 
-// SaveEnvironment will save your environment to tmp folder
+// SaveEnvironment will save your environment to tmp folder or update the already saved one
 func SaveEnvironment(environment structs.Environment) error {
 	err := EnsureSaveFileAvailable()
 	if err != nil {
 		return err
 	}
 
-	isSavedAlready, err := IsEnvironmentSaved(environment.ID)
+	content, err := os.ReadFile(savesFile)
+	if err != nil {
+		return err
+	}
+	targets := []structs.Environment{}
+	err = json.Unmarshal(content, &targets)
 	if err != nil {
 		return err
 	}
 
-	if !isSavedAlready {
-		content, err := os.ReadFile(savesFile)
-		if err != nil {
-			return err
+	isSavedAlready := false
+	for i, env := range targets {
+		if env.ID == environment.ID {
+			// overwrite the saved environment with its latest state
+			targets[i] = environment
+			isSavedAlready = true
 		}
-		targets := []structs.Environment{}
-		err = json.Unmarshal(content, &targets)
-
+	}
+	if !isSavedAlready {
 		targets = append(targets, environment)
-		content, err = json.Marshal(targets)
-		err = os.WriteFile(savesFile, content, 0644)
-		if err != nil {
-			return err
-		}
 	}
-
-	return nil
+	content, err = json.Marshal(targets)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(savesFile, content, 0644)
 }
 
 // GetEnvironment will return your environment from tmp folder
@@ -251,7 +260,7 @@ func RemoveBox(box structs.Box, environmentID string) error {
 	for t, env := range targets {
 		if env.ID == environmentID {
 			for i, savedBox := range env.Boxes {
-				if cmp.Equal(box, savedBox) {
+				if box.Name == savedBox.Name && box.Namespace == savedBox.Namespace {
 					targets[t].Boxes[i] = targets[t].Boxes[len(env.Boxes)-1]
 					targets[t].Boxes = targets[t].Boxes[:len(env.Boxes)-1]
 					if err != nil {
@@ -308,3 +317,117 @@ func RemoveEnvironment(id string) error {
 
 	return nil
 }
+
+// GetEnvironmentKey will return a DNS-1123 compatible key of the environment id that can be used in file and object names
+func GetEnvironmentKey(id string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(id)))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func getHistoryFile(id string) string {
+	return filepath.Join(historyDir, GetEnvironmentKey(id))
+}
+
+// SaveRevision will add the revision to the environment history in tmp folder, keeping only the latest revisions
+func SaveRevision(revision structs.Revision) error {
+	revisions, err := GetRevisions(revision.Environment.ID)
+	if err != nil {
+		return err
+	}
+	revisions = TrimRevisions(append(revisions, revision), structs.MAX_REVISIONS)
+	content, err := json.Marshal(revisions)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(historyDir, 0750)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(getHistoryFile(revision.Environment.ID), content, 0644)
+}
+
+// GetRevisions will return the environment history from tmp folder sorted by the revision number
+func GetRevisions(id string) ([]structs.Revision, error) {
+	content, err := os.ReadFile(getHistoryFile(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	revisions := []structs.Revision{}
+	err = json.Unmarshal(content, &revisions)
+	if err != nil {
+		return nil, err
+	}
+	return TrimRevisions(revisions, len(revisions)), nil
+}
+
+// RemoveRevisions will remove the environment history from tmp folder
+func RemoveRevisions(id string) error {
+	err := os.Remove(getHistoryFile(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// TrimRevisions will sort the revisions by their number and keep only the latest max of them
+func TrimRevisions(revisions []structs.Revision, max int) []structs.Revision {
+	sort.SliceStable(revisions, func(i, j int) bool {
+		return revisions[i].Number < revisions[j].Number
+	})
+	if len(revisions) > max {
+		revisions = revisions[len(revisions)-max:]
+	}
+	return revisions
+}
+
+// SaveStatuses will replace the statuses of the namespace environments observed by the controller in tmp folder.
+// The statuses of the environments that are not saved anymore are dropped.
+func SaveStatuses(namespace string, statuses []structs.EnvironmentStatus) error {
+	saved, err := GetStatuses()
+	if err != nil {
+		return err
+	}
+	environments, err := GetEnvironments()
+	if err != nil {
+		return err
+	}
+	replaced := make(map[string]structs.EnvironmentStatus)
+	for _, environment := range environments {
+		status, ok := saved[environment.ID]
+		if ok && environment.Namespace != namespace {
+			replaced[environment.ID] = status
+		}
+	}
+	for _, status := range statuses {
+		replaced[status.ID] = status
+	}
+	content, err := json.Marshal(replaced)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(saveDir, 0750)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(statusFile, content, 0644)
+}
+
+// GetStatuses will return the environment statuses from tmp folder keyed by the environment id
+func GetStatuses() (map[string]structs.EnvironmentStatus, error) {
+	statuses := make(map[string]structs.EnvironmentStatus)
+	content, err := os.ReadFile(statusFile)
+	if os.IsNotExist(err) {
+		return statuses, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &statuses)
+	if err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
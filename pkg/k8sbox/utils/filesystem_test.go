@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+)
+
+func TestTrimRevisions(t *testing.T) {
+	tests := []struct {
+		name      string
+		revisions []int
+		max       int
+		want      []int
+	}{
+		{name: "no revisions", revisions: nil, max: 3, want: nil},
+		{name: "under the limit", revisions: []int{1, 2}, max: 3, want: []int{1, 2}},
+		{name: "at the limit", revisions: []int{1, 2, 3}, max: 3, want: []int{1, 2, 3}},
+		{name: "over the limit keeps the latest", revisions: []int{1, 2, 3, 4, 5}, max: 3, want: []int{3, 4, 5}},
+		{name: "unsorted revisions", revisions: []int{4, 1, 5, 3, 2}, max: 2, want: []int{4, 5}},
+		{name: "zero limit", revisions: []int{1, 2}, max: 0, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var revisions []structs.Revision
+			for _, number := range tt.revisions {
+				revisions = append(revisions, structs.Revision{Number: number})
+			}
+			var got []int
+			for _, revision := range TrimRevisions(revisions, tt.max) {
+				got = append(got, revision.Number)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TrimRevisions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveStatuses(t *testing.T) {
+	prefix := fmt.Sprintf("status-test-%d", time.Now().UnixNano())
+	kept, reconciled, deleted := prefix+"-kept", prefix+"-reconciled", prefix+"-deleted"
+	for _, environment := range []structs.Environment{
+		{ID: kept, Namespace: "other"},
+		{ID: reconciled, Namespace: "default"},
+		{ID: deleted, Namespace: "default"},
+	} {
+		err := SaveEnvironment(environment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			RemoveEnvironment(environment.ID)
+		})
+	}
+
+	err := SaveStatuses("other", []structs.EnvironmentStatus{{ID: kept, Phase: structs.PHASE_HEALTHY}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveStatuses("default", []structs.EnvironmentStatus{
+		{ID: reconciled, Phase: structs.PHASE_HEALTHY},
+		{ID: deleted, Phase: structs.PHASE_HEALTHY},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RemoveEnvironment(deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveStatuses("default", []structs.EnvironmentStatus{{ID: reconciled, Phase: structs.PHASE_DRIFTED}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := GetStatuses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		id        string
+		wantSaved bool
+		wantPhase structs.EnvironmentPhase
+	}{
+		{id: kept, wantSaved: true, wantPhase: structs.PHASE_HEALTHY},
+		{id: reconciled, wantSaved: true, wantPhase: structs.PHASE_DRIFTED},
+		{id: deleted, wantSaved: false},
+	}
+	for _, tt := range tests {
+		status, ok := statuses[tt.id]
+		if ok != tt.wantSaved {
+			t.Errorf("status of %s is saved = %v, want %v", tt.id, ok, tt.wantSaved)
+		}
+		if ok && status.Phase != tt.wantPhase {
+			t.Errorf("status of %s = %s, want %s", tt.id, status.Phase, tt.wantPhase)
+		}
+	}
+}
+
+func TestRemoveBox(t *testing.T) {
+	id := fmt.Sprintf("box-test-%d", time.Now().UnixNano())
+	installed := structs.Box{
+		Name:           "db",
+		Namespace:      "default",
+		ClusterObjects: []structs.ClusterObject{{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: "db"}},
+	}
+	err := SaveEnvironment(structs.Environment{ID: id, Boxes: []structs.Box{installed, {Name: "api", Namespace: "default"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		RemoveEnvironment(id)
+	})
+
+	// the box of the toml file doesn't know the cluster-scoped objects its install has created
+	err = RemoveBox(structs.Box{Name: "db", Namespace: "default"}, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	environment, err := GetEnvironment(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, box := range environment.Boxes {
+		names = append(names, box.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"api"}) {
+		t.Errorf("saved boxes = %v, want [api]", names)
+	}
+}
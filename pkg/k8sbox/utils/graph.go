@@ -0,0 +1,141 @@
+// Package utils is a useful utils that k8sbox use. Methods are usually exported
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+)
+
+// RunBoxGraph will run the function for every box once all its dependencies are done, running up to concurrency boxes at once.
+// With reverse, a box runs only after every box that depends on it is done.
+// Independent boxes start in the order they are specified. No new boxes are started after a failure.
+func RunBoxGraph(boxes []structs.Box, concurrency int, reverse bool, run func(*structs.Box) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	index := make(map[string]int)
+	for i, box := range boxes {
+		if len(box.Name) != 0 {
+			index[box.Name] = i
+		}
+	}
+
+	// waitFor is a number of boxes that must be done before the box, next are the boxes waiting for the box
+	waitFor := make([]int, len(boxes))
+	next := make([][]int, len(boxes))
+	for i, box := range boxes {
+		for _, dependency := range box.DependsOn {
+			j, ok := index[dependency]
+			if !ok {
+				// unknown dependencies are reported by the boxes validation
+				continue
+			}
+			if reverse {
+				waitFor[j]++
+				next[i] = append(next[i], j)
+			} else {
+				waitFor[i]++
+				next[j] = append(next[j], i)
+			}
+		}
+	}
+
+	type result struct {
+		index int
+		err   error
+	}
+	results := make(chan result)
+	var queue []int
+	for i := range boxes {
+		if waitFor[i] == 0 {
+			queue = append(queue, i)
+		}
+	}
+
+	var messages []string
+	running, done := 0, 0
+	for done < len(boxes) {
+		for len(queue) > 0 && running < concurrency && len(messages) == 0 {
+			i := queue[0]
+			queue = queue[1:]
+			running++
+			go func(i int) {
+				results <- result{index: i, err: run(&boxes[i])}
+			}(i)
+		}
+		if running == 0 {
+			break
+		}
+		r := <-results
+		running--
+		done++
+		if r.err != nil {
+			messages = append(messages, fmt.Sprintf("Box %s: %s", boxes[r.index].Name, r.err))
+			continue
+		}
+		for _, n := range next[r.index] {
+			waitFor[n]--
+			if waitFor[n] == 0 {
+				queue = append(queue, n)
+			}
+		}
+	}
+
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "\n\r"))
+	}
+	if done < len(boxes) {
+		return errors.New("Box dependencies contain a cycle")
+	}
+	return nil
+}
+
+// FindBoxDependencyCycle will return the names of the boxes that form a dependency cycle or nil if there is none
+func FindBoxDependencyCycle(boxes []structs.Box) []string {
+	dependencies := make(map[string][]string)
+	for _, box := range boxes {
+		dependencies[box.Name] = box.DependsOn
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		state[name] = visiting
+		path = append(path, name)
+		for _, dependency := range dependencies[name] {
+			switch state[dependency] {
+			case visiting:
+				for i, n := range path {
+					if n == dependency {
+						return append(append([]string{}, path[i:]...), dependency)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dependency); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, box := range boxes {
+		if state[box.Name] == unvisited {
+			if cycle := visit(box.Name); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
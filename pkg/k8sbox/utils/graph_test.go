@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+)
+
+func TestRunBoxGraph(t *testing.T) {
+	tests := []struct {
+		name    string
+		boxes   []structs.Box
+		reverse bool
+		fail    string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "independent boxes run in order",
+			boxes: []structs.Box{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name: "dependencies run first",
+			boxes: []structs.Box{
+				{Name: "web", DependsOn: []string{"api"}},
+				{Name: "api", DependsOn: []string{"db"}},
+				{Name: "db"},
+			},
+			want: []string{"db", "api", "web"},
+		},
+		{
+			name: "reverse runs the dependents first",
+			boxes: []structs.Box{
+				{Name: "web", DependsOn: []string{"api"}},
+				{Name: "api", DependsOn: []string{"db"}},
+				{Name: "db"},
+			},
+			reverse: true,
+			want:    []string{"web", "api", "db"},
+		},
+		{
+			name: "unknown dependencies are ignored",
+			boxes: []structs.Box{
+				{Name: "web", DependsOn: []string{"missing"}},
+			},
+			want: []string{"web"},
+		},
+		{
+			name: "no boxes start after a failure",
+			boxes: []structs.Box{
+				{Name: "db"},
+				{Name: "api", DependsOn: []string{"db"}},
+			},
+			fail:    "db",
+			want:    []string{"db"},
+			wantErr: "Box db: failed",
+		},
+		{
+			name: "cycle",
+			boxes: []structs.Box{
+				{Name: "a", DependsOn: []string{"b"}},
+				{Name: "b", DependsOn: []string{"a"}},
+			},
+			wantErr: "Box dependencies contain a cycle",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := RunBoxGraph(tt.boxes, 1, tt.reverse, func(box *structs.Box) error {
+				got = append(got, box.Name)
+				if box.Name == tt.fail {
+					return errors.New("failed")
+				}
+				return nil
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RunBoxGraph() ran %v, want %v", got, tt.want)
+			}
+			if len(tt.wantErr) == 0 && err != nil {
+				t.Errorf("RunBoxGraph() error = %s", err)
+			}
+			if len(tt.wantErr) != 0 && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("RunBoxGraph() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunBoxGraphConcurrency(t *testing.T) {
+	boxes := []structs.Box{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}}
+	var mu sync.Mutex
+	running, peak := 0, 0
+	release := make(chan struct{})
+	go func() {
+		for range boxes {
+			release <- struct{}{}
+		}
+	}()
+	err := RunBoxGraph(boxes, 2, false, func(box *structs.Box) error {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("RunBoxGraph() error = %s", err)
+	}
+	if peak > 2 {
+		t.Errorf("RunBoxGraph() ran %d boxes at once, want at most 2", peak)
+	}
+}
+
+func TestFindBoxDependencyCycle(t *testing.T) {
+	tests := []struct {
+		name  string
+		boxes []structs.Box
+		want  []string
+	}{
+		{
+			name: "no cycle",
+			boxes: []structs.Box{
+				{Name: "web", DependsOn: []string{"api", "db"}},
+				{Name: "api", DependsOn: []string{"db"}},
+				{Name: "db"},
+			},
+			want: nil,
+		},
+		{
+			name: "self dependency",
+			boxes: []structs.Box{
+				{Name: "a", DependsOn: []string{"a"}},
+			},
+			want: []string{"a", "a"},
+		},
+		{
+			name: "cycle behind a dependency",
+			boxes: []structs.Box{
+				{Name: "web", DependsOn: []string{"api"}},
+				{Name: "api", DependsOn: []string{"db"}},
+				{Name: "db", DependsOn: []string{"api"}},
+			},
+			want: []string{"api", "db", "api"},
+		},
+		{
+			name: "unknown dependencies are not a cycle",
+			boxes: []structs.Box{
+				{Name: "web", DependsOn: []string{"missing"}},
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FindBoxDependencyCycle(tt.boxes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindBoxDependencyCycle() = %s, want %s", strings.Join(got, " -> "), strings.Join(tt.want, " -> "))
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+// Package utils is a useful utils that k8sbox use. Methods are usually exported
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+)
+
+// GetInvoker will return who runs k8sbox: the CI job when k8sbox runs in CI, the OS user otherwise.
+// K8SBOX_INVOKER overrides the detection.
+func GetInvoker() string {
+	if invoker := strings.TrimSpace(os.Getenv("K8SBOX_INVOKER")); len(invoker) > 0 {
+		return invoker
+	}
+	switch {
+	case len(os.Getenv("GITLAB_CI")) > 0:
+		return fmt.Sprintf("gitlab job %s (%s)", os.Getenv("CI_JOB_ID"), os.Getenv("CI_JOB_URL"))
+	case len(os.Getenv("GITHUB_ACTIONS")) > 0:
+		return fmt.Sprintf("github run %s (%s/%s/actions/runs/%s)", os.Getenv("GITHUB_RUN_ID"), os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID"))
+	case len(os.Getenv("JENKINS_URL")) > 0:
+		return fmt.Sprintf("jenkins build %s", os.Getenv("BUILD_URL"))
+	case len(os.Getenv("CI")) > 0:
+		return "ci"
+	}
+	u, err := user.Current()
+	if err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
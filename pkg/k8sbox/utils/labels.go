@@ -0,0 +1,101 @@
+// Package utils is a useful utils that k8sbox use. Methods are usually exported
+package utils
+
+import (
+	"fmt"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// podTemplatePaths are the pod templates of the workload kinds, keyed by group and kind
+var podTemplatePaths = map[string][][]string{
+	"/ReplicationController": {{"spec", "template"}},
+	"/PodTemplate":           {{"template"}},
+	"apps/Deployment":        {{"spec", "template"}},
+	"apps/StatefulSet":       {{"spec", "template"}},
+	"apps/DaemonSet":         {{"spec", "template"}},
+	"apps/ReplicaSet":        {{"spec", "template"}},
+	"batch/Job":              {{"spec", "template"}},
+	"batch/CronJob":          {{"spec", "jobTemplate"}, {"spec", "jobTemplate", "spec", "template"}},
+}
+
+// GetOwnershipLabels will return the labels that tie an object to its environment and box.
+// An environment is identified by its namespace and its id, the same id may be used in another namespace.
+func GetOwnershipLabels(environmentID string, namespace string, box string) map[string]string {
+	return map[string]string{
+		structs.LABEL_MANAGED_BY:            structs.MANAGED_BY,
+		structs.LABEL_ENVIRONMENT_ID:        environmentID,
+		structs.LABEL_ENVIRONMENT_NAMESPACE: namespace,
+		structs.LABEL_BOX:                   box,
+	}
+}
+
+// GetEnvironmentSelector will return a label selector that matches every object of the environment
+func GetEnvironmentSelector(environmentID string, namespace string) string {
+	return fmt.Sprintf("%s=%s,%s=%s,%s=%s", structs.LABEL_MANAGED_BY, structs.MANAGED_BY,
+		structs.LABEL_ENVIRONMENT_ID, environmentID, structs.LABEL_ENVIRONMENT_NAMESPACE, namespace)
+}
+
+// AddOwnershipLabels will add the ownership labels to every manifest of the render and to the pod templates of its workloads.
+// Multi-document templates are split, notes and partials are dropped.
+func AddOwnershipLabels(render map[string]string, environmentID string, namespace string, box string) (map[string]string, error) {
+	labels := GetOwnershipLabels(environmentID, namespace, box)
+	labelled := make(map[string]string)
+	for name, manifest := range CleanHelmRender(render) {
+		document, err := addManifestLabels(manifest, labels)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		labelled[name] = document
+	}
+	return labelled, nil
+}
+
+func addManifestLabels(manifest string, labels map[string]string) (string, error) {
+	data, err := yaml.YAMLToJSON([]byte(manifest))
+	if err != nil {
+		return "", err
+	}
+	obj := &unstructured.Unstructured{}
+	err = obj.UnmarshalJSON(data)
+	if err != nil {
+		return "", err
+	}
+
+	obj.SetLabels(mergeLabels(obj.GetLabels(), labels))
+	gvk := obj.GroupVersionKind()
+	for _, path := range podTemplatePaths[gvk.Group+"/"+gvk.Kind] {
+		fields := append(append([]string{}, path...), "metadata", "labels")
+		existing, _, err := unstructured.NestedStringMap(obj.Object, fields...)
+		if err != nil {
+			return "", err
+		}
+		err = unstructured.SetNestedStringMap(obj.Object, mergeLabels(existing, labels), fields...)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	data, err = obj.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	out, err := yaml.JSONToYAML(data)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func mergeLabels(existing map[string]string, labels map[string]string) map[string]string {
+	merged := make(map[string]string, len(existing)+len(labels))
+	for key, value := range existing {
+		merged[key] = value
+	}
+	for key, value := range labels {
+		merged[key] = value
+	}
+	return merged
+}
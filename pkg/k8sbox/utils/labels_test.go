@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/twelvee/k8sbox/pkg/k8sbox/structs"
+	"sigs.k8s.io/yaml"
+)
+
+func TestAddOwnershipLabels(t *testing.T) {
+	owned := GetOwnershipLabels("env", "default", "web")
+	withLabels := func(labels map[string]string) map[string]string {
+		return mergeLabels(labels, owned)
+	}
+	tests := []struct {
+		name     string
+		manifest string
+		// paths are the fields whose labels are checked, the first one is the object labels
+		paths [][]string
+		want  []map[string]string
+	}{
+		{
+			name:     "object without labels",
+			manifest: "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\n",
+			paths:    [][]string{{"metadata", "labels"}},
+			want:     []map[string]string{owned},
+		},
+		{
+			name:     "existing labels are kept",
+			manifest: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web\n  labels:\n    app: web\n",
+			paths:    [][]string{{"metadata", "labels"}},
+			want:     []map[string]string{withLabels(map[string]string{"app": "web"})},
+		},
+		{
+			name:     "ownership labels win",
+			manifest: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web\n  labels:\n    " + structs.LABEL_BOX + ": other\n",
+			paths:    [][]string{{"metadata", "labels"}},
+			want:     []map[string]string{owned},
+		},
+		{
+			name: "deployment pod template",
+			manifest: "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\n" +
+				"spec:\n  template:\n    metadata:\n      labels:\n        app: web\n",
+			paths: [][]string{{"metadata", "labels"}, {"spec", "template", "metadata", "labels"}},
+			want:  []map[string]string{owned, withLabels(map[string]string{"app": "web"})},
+		},
+		{
+			name:     "job pod template without metadata",
+			manifest: "apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: migrate\nspec:\n  template:\n    spec: {}\n",
+			paths:    [][]string{{"metadata", "labels"}, {"spec", "template", "metadata", "labels"}},
+			want:     []map[string]string{owned, owned},
+		},
+		{
+			name:     "cronjob job and pod templates",
+			manifest: "apiVersion: batch/v1\nkind: CronJob\nmetadata:\n  name: cleanup\nspec:\n  jobTemplate:\n    spec:\n      template:\n        spec: {}\n",
+			paths: [][]string{
+				{"metadata", "labels"},
+				{"spec", "jobTemplate", "metadata", "labels"},
+				{"spec", "jobTemplate", "spec", "template", "metadata", "labels"},
+			},
+			want: []map[string]string{owned, owned, owned},
+		},
+		{
+			name:     "template of an unknown kind is left alone",
+			manifest: "apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: web\nspec:\n  template:\n    spec: {}\n",
+			paths:    [][]string{{"metadata", "labels"}, {"spec", "template", "metadata", "labels"}},
+			want:     []map[string]string{owned, nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			render, err := AddOwnershipLabels(map[string]string{"web/templates/object.yaml": tt.manifest}, "env", "default", "web")
+			if err != nil {
+				t.Fatalf("AddOwnershipLabels() error = %s", err)
+			}
+			var obj map[string]interface{}
+			err = yaml.Unmarshal([]byte(render["web/templates/object.yaml"]), &obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, path := range tt.paths {
+				if got := getNestedLabels(obj, path); !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("%v = %v, want %v", path, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAddOwnershipLabelsSplitsDocuments(t *testing.T) {
+	render, err := AddOwnershipLabels(map[string]string{
+		"web/templates/all.yaml":     "apiVersion: v1\nkind: Service\n---\napiVersion: v1\nkind: ConfigMap\n",
+		"web/templates/NOTES.txt":    "Thanks for installing web",
+		"web/templates/_helpers.tpl": "",
+	}, "env", "default", "web")
+	if err != nil {
+		t.Fatalf("AddOwnershipLabels() error = %s", err)
+	}
+	var names []string
+	for name := range render {
+		names = append(names, name)
+	}
+	if len(names) != 2 || len(render["web/templates/all.yaml#0"]) == 0 || len(render["web/templates/all.yaml#1"]) == 0 {
+		t.Errorf("AddOwnershipLabels() templates = %v, want web/templates/all.yaml#0 and web/templates/all.yaml#1", names)
+	}
+}
+
+func getNestedLabels(obj map[string]interface{}, path []string) map[string]string {
+	var current interface{} = obj
+	for _, field := range path {
+		fields, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = fields[field]
+	}
+	values, ok := current.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	labels := make(map[string]string, len(values))
+	for key, value := range values {
+		labels[key], _ = value.(string)
+	}
+	return labels
+}
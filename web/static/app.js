@@ -0,0 +1,408 @@
+"use strict";
+
+// The dashboard talks to the REST API of k8sbox serve with the token kept for the browser session
+const TOKEN_KEY = "k8sbox-token";
+const REFRESH_INTERVAL = 10000;
+const POLL_INTERVAL = 2000;
+
+const state = {
+  environment: null,
+  pod: null,
+  operations: [],
+  timer: null,
+};
+
+const $ = (id) => document.getElementById(id);
+
+class UnauthorizedError extends Error {}
+
+async function request(method, path, accept) {
+  const response = await fetch(path, {
+    method: method,
+    headers: {
+      Authorization: "Bearer " + sessionStorage.getItem(TOKEN_KEY),
+      Accept: accept || "application/json",
+    },
+  });
+  if (response.status === 401) {
+    throw new UnauthorizedError("The token is not valid");
+  }
+  if (!response.ok) {
+    let message = response.statusText;
+    try {
+      message = (await response.json()).error || message;
+    } catch (e) {
+      // the body is not JSON, the status text explains enough
+    }
+    throw new Error(message);
+  }
+  if (accept === "text/plain") {
+    return response.text();
+  }
+  return response.json();
+}
+
+function element(tag, text, className) {
+  const node = document.createElement(tag);
+  if (text !== undefined && text !== null) {
+    node.textContent = String(text);
+  }
+  if (className) {
+    node.className = className;
+  }
+  return node;
+}
+
+function stateElement(value) {
+  return element("span", value || "-", "state state-" + (value || "none"));
+}
+
+function row(cells) {
+  const tr = document.createElement("tr");
+  for (const cell of cells) {
+    const td = document.createElement("td");
+    if (cell instanceof Node) {
+      td.appendChild(cell);
+    } else {
+      td.textContent = cell === undefined || cell === null || cell === "" ? "-" : String(cell);
+    }
+    tr.appendChild(td);
+  }
+  return tr;
+}
+
+function age(timestamp) {
+  if (!timestamp) {
+    return "-";
+  }
+  const seconds = Math.max(0, Math.floor((Date.now() - new Date(timestamp).getTime()) / 1000));
+  if (seconds < 60) {
+    return seconds + "s";
+  }
+  if (seconds < 3600) {
+    return Math.floor(seconds / 60) + "m";
+  }
+  if (seconds < 86400) {
+    return Math.floor(seconds / 3600) + "h";
+  }
+  return Math.floor(seconds / 86400) + "d";
+}
+
+function expiry(environment) {
+  if (!environment.expiresAt) {
+    return "never";
+  }
+  const left = new Date(environment.expiresAt).getTime() - Date.now();
+  if (left <= 0) {
+    return "expired";
+  }
+  return "in " + age(new Date(Date.now() - left).toISOString());
+}
+
+function showMessage(error) {
+  if (error instanceof UnauthorizedError) {
+    logout();
+    return;
+  }
+  const message = $("message");
+  message.textContent = error ? error.message : "";
+  message.hidden = !error;
+}
+
+function environmentPath(environment) {
+  return "/v1/environments/" + encodeURIComponent(environment.namespace) + "/" + encodeURIComponent(environment.id);
+}
+
+async function loadEnvironments() {
+  const namespace = $("namespace").value;
+  const query = "?health=true" + (namespace ? "&namespace=" + encodeURIComponent(namespace) : "");
+  const environments = await request("GET", "/v1/environments" + query);
+  if (!namespace) {
+    updateNamespaces(environments);
+  }
+
+  const groups = $("environment-groups");
+  groups.replaceChildren();
+  if (environments.length === 0) {
+    groups.appendChild(element("p", "No environments found."));
+    return;
+  }
+  const byNamespace = new Map();
+  for (const environment of environments) {
+    if (!byNamespace.has(environment.namespace)) {
+      byNamespace.set(environment.namespace, []);
+    }
+    byNamespace.get(environment.namespace).push(environment);
+  }
+  for (const [name, items] of byNamespace) {
+    groups.appendChild(element("h3", name));
+    const table = element("table");
+    const head = row(["ID", "Name", "Boxes", "Revision", "Age", "TTL", "Expires", "Health", "Controller"]);
+    head.querySelectorAll("td").forEach((td) => td.replaceWith(element("th", td.textContent)));
+    table.appendChild(element("thead")).appendChild(head);
+    const body = table.appendChild(element("tbody"));
+    for (const environment of items) {
+      const tr = row([
+        environment.id,
+        environment.name,
+        environment.boxes.join(", "),
+        environment.revision,
+        age(environment.createdAt),
+        environment.ttl,
+        expiry(environment),
+        stateElement(environment.health),
+        stateElement(environment.phase),
+      ]);
+      tr.className = "clickable";
+      tr.addEventListener("click", () => openEnvironment(environment));
+      body.appendChild(tr);
+    }
+    groups.appendChild(table);
+  }
+}
+
+function updateNamespaces(environments) {
+  const select = $("namespace");
+  const known = new Set(Array.from(select.options).map((option) => option.value));
+  for (const namespace of new Set(environments.map((environment) => environment.namespace))) {
+    if (!known.has(namespace)) {
+      select.appendChild(new Option(namespace, namespace));
+    }
+  }
+}
+
+async function openEnvironment(environment) {
+  state.environment = environment;
+  state.pod = null;
+  $("environments").hidden = true;
+  $("environment").hidden = false;
+  $("logs").hidden = true;
+  await loadEnvironment();
+}
+
+function closeEnvironment() {
+  state.environment = null;
+  state.pod = null;
+  $("environment").hidden = true;
+  $("logs").hidden = true;
+  $("environments").hidden = false;
+  refresh();
+}
+
+async function loadEnvironment() {
+  const path = environmentPath(state.environment);
+  const [description, objects, pods] = await Promise.all([
+    request("GET", path),
+    request("GET", path + "/objects"),
+    request("GET", path + "/pods"),
+  ]);
+  const environment = description.environment;
+  $("environment-title").textContent = environment.namespace + "/" + environment.id;
+
+  const info = $("environment-info");
+  info.replaceChildren();
+  const details = [
+    ["Name", environment.name],
+    ["Revision", environment.revision],
+    ["Age", age(environment.createdAt)],
+    ["Updated", environment.updatedAt ? new Date(environment.updatedAt).toLocaleString() : "-"],
+    ["TTL", environment.ttl || "-"],
+    ["Expires", expiry(environment)],
+    ["Health", stateElement(environment.health)],
+    ["Controller", stateElement(environment.phase)],
+  ];
+  if (description.status && description.status.message) {
+    details.push(["Controller message", description.status.message]);
+  }
+  for (const [name, value] of details) {
+    info.appendChild(element("dt", name));
+    const dd = info.appendChild(element("dd"));
+    if (value instanceof Node) {
+      dd.appendChild(value);
+    } else {
+      dd.textContent = String(value);
+    }
+  }
+
+  const boxes = $("boxes");
+  boxes.replaceChildren();
+  for (const box of description.boxes) {
+    const container = boxes.appendChild(element("div", null, "box"));
+    const title = container.appendChild(element("h4", box.name + " (" + box.type + ", " + box.namespace + ") "));
+    title.appendChild(element("span", box.ready + "/" + box.total + " ready"));
+    if (box.error) {
+      container.appendChild(element("p", box.error, "problems"));
+    }
+    for (const problem of box.problems || []) {
+      const lines = [problem.kind + " " + problem.name + ": " + problem.reason].concat(problem.problems || [], problem.events || []);
+      container.appendChild(element("p", lines.join("\n"), "problems"));
+    }
+    const table = container.appendChild(element("table"));
+    const head = row(["Kind", "Namespace", "Name", "State", "Reason", "Age"]);
+    head.querySelectorAll("td").forEach((td) => td.replaceWith(element("th", td.textContent)));
+    table.appendChild(element("thead")).appendChild(head);
+    const body = table.appendChild(element("tbody"));
+    for (const object of objects.filter((object) => object.box === box.name)) {
+      body.appendChild(row([object.kind, object.namespace, object.name, stateElement(object.state), object.reason, age(object.createdAt)]));
+    }
+  }
+
+  const podRows = $("pods").querySelector("tbody");
+  podRows.replaceChildren();
+  for (const pod of pods) {
+    const logs = element("button", "Logs");
+    logs.type = "button";
+    logs.addEventListener("click", () => openLogs(pod));
+    podRows.appendChild(row([pod.box, pod.namespace, pod.name, stateElement(pod.phase), pod.ready ? "yes" : "no", pod.restarts, age(pod.createdAt), logs]));
+  }
+  if (pods.length === 0) {
+    podRows.appendChild(row(["No pods found."]));
+  }
+}
+
+async function openLogs(pod) {
+  state.pod = pod;
+  $("logs").hidden = false;
+  $("logs-title").textContent = pod.namespace + "/" + pod.name;
+  const select = $("logs-container");
+  select.replaceChildren();
+  for (const container of pod.containers) {
+    select.appendChild(new Option(container, container));
+  }
+  select.value = pod.containers[pod.containers.length - 1];
+  await loadLogs();
+  $("logs").scrollIntoView();
+}
+
+async function loadLogs() {
+  const pod = state.pod;
+  const query = new URLSearchParams({
+    namespace: pod.namespace,
+    pod: pod.name,
+    container: $("logs-container").value,
+    tail: $("logs-tail").value,
+    previous: $("logs-previous").checked,
+  });
+  const output = $("logs-output");
+  try {
+    output.textContent = await request("GET", environmentPath(state.environment) + "/logs?" + query, "text/plain");
+    output.scrollTop = output.scrollHeight;
+  } catch (error) {
+    output.textContent = error.message;
+  }
+}
+
+async function loadOperations() {
+  state.operations = await request("GET", "/v1/operations");
+  const body = $("operations").querySelector("tbody");
+  body.replaceChildren();
+  for (const operation of state.operations) {
+    body.appendChild(row([
+      new Date(operation.createdAt).toLocaleString(),
+      operation.type,
+      operation.namespace + "/" + operation.environment,
+      stateElement(operation.state),
+      operation.revision,
+      operation.error,
+    ]));
+  }
+  if (state.operations.length === 0) {
+    body.appendChild(row(["No operations yet."]));
+  }
+}
+
+async function startOperation(method, path, question) {
+  if (!confirm(question)) {
+    return;
+  }
+  try {
+    await request(method, path);
+    showMessage(null);
+  } catch (error) {
+    showMessage(error);
+  }
+  await refresh();
+}
+
+async function refresh() {
+  clearTimeout(state.timer);
+  try {
+    if (state.environment) {
+      await loadEnvironment();
+    } else {
+      await loadEnvironments();
+    }
+    await loadOperations();
+    showMessage(null);
+  } catch (error) {
+    if (error instanceof UnauthorizedError) {
+      logout();
+      return;
+    }
+    if (state.environment && /not found/.test(error.message)) {
+      closeEnvironment();
+      return;
+    }
+    showMessage(error);
+  }
+  // the operations are polled faster until every one of them is finished
+  const running = state.operations.some((operation) => operation.state === "pending" || operation.state === "running");
+  state.timer = setTimeout(refresh, running ? POLL_INTERVAL : REFRESH_INTERVAL);
+}
+
+function login(token) {
+  sessionStorage.setItem(TOKEN_KEY, token);
+  $("login").hidden = true;
+  $("app").hidden = false;
+  $("refresh").hidden = false;
+  $("logout").hidden = false;
+  refresh();
+}
+
+function logout() {
+  clearTimeout(state.timer);
+  sessionStorage.removeItem(TOKEN_KEY);
+  state.environment = null;
+  $("app").hidden = true;
+  $("refresh").hidden = true;
+  $("logout").hidden = true;
+  $("login").hidden = false;
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  $("login-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    login($("token").value);
+    $("token").value = "";
+  });
+  $("logout").addEventListener("click", logout);
+  $("refresh").addEventListener("click", refresh);
+  $("namespace").addEventListener("change", () => {
+    if (state.environment) {
+      closeEnvironment();
+    } else {
+      refresh();
+    }
+  });
+  $("back").addEventListener("click", closeEnvironment);
+  $("redeploy").addEventListener("click", () => {
+    const environment = state.environment;
+    startOperation("POST", environmentPath(environment) + "/redeploy", "Redeploy environment " + environment.id + "?");
+  });
+  $("delete").addEventListener("click", () => {
+    const environment = state.environment;
+    startOperation("DELETE", environmentPath(environment), "Delete environment " + environment.id + " and every object of it?");
+  });
+  $("logs-refresh").addEventListener("click", loadLogs);
+  $("logs-container").addEventListener("change", loadLogs);
+  $("logs-close").addEventListener("click", () => {
+    state.pod = null;
+    $("logs").hidden = true;
+  });
+
+  if (sessionStorage.getItem(TOKEN_KEY)) {
+    login(sessionStorage.getItem(TOKEN_KEY));
+  } else {
+    logout();
+  }
+});
@@ -0,0 +1,11 @@
+// Package web contains the web dashboard k8sbox serve hosts
+package web
+
+import (
+	"embed"
+)
+
+// Static are the static assets of the dashboard, they are embedded so the k8sbox binary stays a single file
+//
+//go:embed static
+var Static embed.FS